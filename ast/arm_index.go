@@ -0,0 +1,231 @@
+package ast
+
+import (
+	"strings"
+	"sync"
+)
+
+// Arms of a pattern indexed by the shape of the value each of their matches
+// accepts, one column of matches at a time. Applying a value only tests the
+// arms whose match could accept it, so wide patterns like lookup tables don't
+// scan every arm. Each column is indexed on its own by the outermost shape of
+// its matches: lists by their length, list constructors as any non empty list
+// or string, and guards by the match they guard. Constant time guards on a
+// plain identifier only depend on the applied value, so they are tested as
+// cheap filters while narrowing the arms. The index is built once, on first
+// application, and shared by the partial applications of the pattern.
+type armIndex struct {
+	once    sync.Once
+	columns []*columnIndex
+}
+
+// Arms by the values a single column of matches accepts. Every list of arms
+// is in source order so the first matching arm still wins.
+type columnIndex struct {
+	labels  map[string][]int
+	numbers map[float64][]int
+	strings map[string][]int
+
+	// Lists of exactly this length, an empty list also matches ""
+	lengths map[int][]int
+
+	// List constructors, matching any non empty list or string
+	cons []int
+
+	// Identifiers and anything else that can only be decided at match time
+	dynamic []int
+
+	// Constant time guards of arms, tested before the arm is matched
+	filters map[int]*guardFilter
+	groups  int
+}
+
+// A constant time guard on an identifier. It can't have effects, so arms
+// guarded alike in the same environment share the result of testing it.
+type guardFilter struct {
+	match Identifier
+	guard Where
+	group int
+
+	// Guards are alike when they're written alike
+	source string
+}
+
+func newColumnIndex() *columnIndex {
+	return &columnIndex{
+		map[string][]int{},
+		map[float64][]int{},
+		map[string][]int{},
+		map[int][]int{},
+		[]int{},
+		[]int{},
+		map[int]*guardFilter{},
+		0,
+	}
+}
+
+func (x *armIndex) build(a Pattern) {
+	x.once.Do(func() {
+		if len(a.Matches) == 0 {
+			return
+		}
+
+		for i := range a.Matches[0] {
+			column := newColumnIndex()
+
+			for arm, matchGroup := range a.Matches {
+				column.add(arm, matchGroup[i])
+			}
+
+			x.columns = append(x.columns, column)
+		}
+	})
+}
+
+func (c *columnIndex) add(arm int, m AST) {
+	switch M := m.(type) {
+	case Where:
+		// Other guards are checked by patternMatch, the value still has to
+		// fit the inner match
+		if id, ok := M.Match.(Identifier); ok && M.ConstantTime {
+			c.addFilter(arm, id, M)
+		}
+
+		c.add(arm, M.Match)
+
+	case Label:
+		c.labels[M.Value] = append(c.labels[M.Value], arm)
+
+	case Number:
+		c.numbers[float64(M.Value)] = append(c.numbers[float64(M.Value)], arm)
+
+	case Decimal:
+		c.numbers[M.Value] = append(c.numbers[M.Value], arm)
+
+	case String:
+		c.strings[M.Value] = append(c.strings[M.Value], arm)
+
+	case List:
+		c.lengths[len(M.Values)] = append(c.lengths[len(M.Values)], arm)
+
+	case ListConstructor:
+		c.cons = append(c.cons, arm)
+
+	default:
+		c.dynamic = append(c.dynamic, arm)
+	}
+}
+
+func (c *columnIndex) addFilter(arm int, id Identifier, guard Where) {
+	filter := &guardFilter{id, guard, c.groups, strings.Join(guard.Condition.String(), "\n")}
+
+	for _, other := range c.filters {
+		if other.match.Value == id.Value && other.source == filter.source {
+			filter.group = other.group
+		}
+	}
+
+	if filter.group == c.groups {
+		c.groups++
+	}
+
+	c.filters[arm] = filter
+}
+
+// Results of the filters tested for one applied value
+type filterResults map[filterKey]bool
+
+type filterKey struct {
+	group int
+	env   *Environment
+}
+
+// Tests the guard of an arm with the value bound, in an environment of its
+// own. Identifiers already bound where the arm is matched are matched by
+// equality, so those guards aren't tested here and are left to patternMatch.
+func (f *guardFilter) test(results filterResults, parent *Environment, from *Environment, val AST) (bool, bool, error) {
+	if _, ok := parent.Get(f.match.Value); ok {
+		return false, false, nil
+	}
+
+	key := filterKey{f.group, parent}
+
+	if passed, ok := results[key]; ok {
+		return passed, true, nil
+	}
+
+	env := armEnv(parent, from)
+	env.Set(f.match.Value, val)
+
+	if err := checkConstantTime(f.guard.Condition, env); err != nil {
+		return false, false, NewRuntimeErrorAt(f.guard.Pos, err, "in constant time guard")
+	}
+
+	cond, err := f.guard.Condition.Eval(env)
+
+	if err != nil {
+		return false, false, NewRuntimeErrorAt(f.guard.Pos, err, "in constant time guard")
+	}
+
+	results[key] = cond.Equals(True)
+
+	return results[key], true, nil
+}
+
+// Arms that may match the value, every arm that can is included but not
+// every arm included will
+func (c *columnIndex) candidates(val AST) []int {
+	switch V := val.(type) {
+	case Label:
+		return mergeArms(c.labels[V.Value], c.dynamic)
+
+	case Number:
+		return mergeArms(c.numbers[float64(V.Value)], c.dynamic)
+
+	case Decimal:
+		return mergeArms(c.numbers[V.Value], c.dynamic)
+
+	case String:
+		if len(V.Value) == 0 {
+			return mergeArms(mergeArms(c.strings[V.Value], c.lengths[0]), c.dynamic)
+		}
+
+		return mergeArms(mergeArms(c.strings[V.Value], c.cons), c.dynamic)
+
+	case List:
+		if len(V.Values) == 0 {
+			return mergeArms(c.lengths[0], c.dynamic)
+		}
+
+		return mergeArms(mergeArms(c.lengths[len(V.Values)], c.cons), c.dynamic)
+	}
+
+	return c.dynamic
+}
+
+// Merges two lists of arms, both in source order
+func mergeArms(a []int, b []int) []int {
+	if len(a) == 0 {
+		return b
+	}
+
+	if len(b) == 0 {
+		return a
+	}
+
+	res := make([]int, 0, len(a)+len(b))
+
+	for len(a) > 0 && len(b) > 0 {
+		if a[0] < b[0] {
+			res = append(res, a[0])
+			a = a[1:]
+		} else {
+			res = append(res, b[0])
+			b = b[1:]
+		}
+	}
+
+	res = append(res, a...)
+
+	return append(res, b...)
+}
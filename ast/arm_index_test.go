@@ -0,0 +1,171 @@
+package ast
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+// A lookup table of labels, the last of which is applied
+func lookupTable(tb testing.TB, arms int) (Pattern, Label) {
+	src := "package table\n\n{\n"
+
+	for i := 0; i < arms; i++ {
+		src += fmt.Sprintf("  .label_%d -> %d\n", i, i)
+	}
+
+	file, err := Parse("table.sl", []byte(src+"}\n"))
+
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	pattern, err := file.Definition.Eval(NewLimitedEnv(NewBudget(context.Background(), Limits{})))
+
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return pattern.(Pattern), Label{Value: fmt.Sprintf("label_%d", arms-1)}
+}
+
+// An index where every arm is a candidate, as when scanning every arm
+func linearIndex(a Pattern) *armIndex {
+	x := &armIndex{}
+	x.once.Do(func() {})
+
+	for range a.Matches[0] {
+		column := newColumnIndex()
+
+		for arm := range a.Matches {
+			column.dynamic = append(column.dynamic, arm)
+		}
+
+		x.columns = append(x.columns, column)
+	}
+
+	return x
+}
+
+func BenchmarkArmIndex(b *testing.B) {
+	for _, arms := range []int{4, 16, 64, 256} {
+		pattern, label := lookupTable(b, arms)
+		linear := pattern
+		linear.index = linearIndex(pattern)
+
+		for _, bench := range []struct {
+			name    string
+			pattern Pattern
+		}{{"indexed", pattern}, {"linear", linear}} {
+			bench := bench
+
+			b.Run(fmt.Sprintf("%s/%d", bench.name, arms), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, err := bench.pattern.Apply(label); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// Applying a value only visits its candidates, so wider tables don't cost
+// more to apply
+func TestArmIndexOnlyVisitsCandidates(t *testing.T) {
+	const runs = 100
+	allocated := map[int]uint64{}
+
+	for _, arms := range []int{16, 256} {
+		pattern, label := lookupTable(t, arms)
+		var before, after runtime.MemStats
+
+		// The index is built by the first application
+		if _, err := pattern.Apply(label); err != nil {
+			t.Fatal(err)
+		}
+
+		runtime.ReadMemStats(&before)
+
+		for i := 0; i < runs; i++ {
+			if _, err := pattern.Apply(label); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		runtime.ReadMemStats(&after)
+		allocated[arms] = (after.TotalAlloc - before.TotalAlloc) / runs
+	}
+
+	if allocated[256] > allocated[16] {
+		t.Errorf("expected as many bytes allocated for 256 arms as for 16, got %d and %d", allocated[256], allocated[16])
+	}
+}
+
+// The index only narrows the arms tested, the first matching arm still wins
+func TestArmIndexMatchesLinearScan(t *testing.T) {
+	src := `package arms
+
+{
+  .a         -> 1
+  [x]        -> 2
+  n : (n > 5) -> 3
+  5          -> 4
+  [x:xs]     -> 5
+  ""         -> 6
+  []         -> 7
+  "b"        -> 8
+  x          -> 9
+}
+`
+	file, err := Parse("arms.sl", []byte(src))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lib := DefaultBuiltins().Let()
+	lib.Body = file.Definition
+	res, err := lib.Eval(NewLimitedEnv(NewBudget(context.Background(), Limits{})))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pattern := res.(Pattern)
+	linear := pattern
+	linear.index = linearIndex(pattern)
+
+	values := []AST{
+		Label{Value: "a"},
+		Label{Value: "b"},
+		List{Values: []AST{Number{Value: 1}}},
+		List{Values: []AST{Number{Value: 1}, Number{Value: 2}}},
+		List{},
+		Number{Value: 5},
+		Number{Value: 6},
+		Decimal{Value: 5},
+		String{Value: ""},
+		String{Value: "b"},
+		String{Value: "bc"},
+	}
+
+	for _, v := range values {
+		indexed, err := pattern.Apply(v)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		scanned, err := linear.Apply(v)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !indexed.Equals(scanned) {
+			t.Errorf("%s matched %s indexed but %s scanning every arm", v.String(), indexed.String(), scanned.String())
+		}
+	}
+}
@@ -3,7 +3,11 @@ package ast
 import (
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 /*
@@ -18,6 +22,7 @@ String
 List
 ListConstructor
 Number
+Decimal
 Let
 Where
 */
@@ -40,10 +45,14 @@ type AST interface {
 	Copy() AST
 }
 
-// Package name and imports
+// Package name and imports. An import binds its package under its name, or
+// only the names it selects from the package when it has some.
 type SourceFileImport struct {
-	Path string
-	Name string
+	Path  string
+	Name  string
+	Names []string
+
+	Pos Position
 }
 
 type SourceFile struct {
@@ -81,6 +90,10 @@ func (s *SourceFile) Print() {
 			fmt.Printf(" as %s", imp.Name)
 		}
 
+		if len(imp.Names) > 0 {
+			fmt.Printf(" (%s)", strings.Join(imp.Names, ", "))
+		}
+
 		fmt.Println()
 	}
 
@@ -91,11 +104,14 @@ func (s *SourceFile) Print() {
 
 type Application struct {
 	Body []AST
+
+	Pos Position
 }
 
 func NewApplication(body []AST) (Application, error) {
 	return Application{
 		body,
+		Position{},
 	}, nil
 }
 
@@ -103,6 +119,13 @@ type Pattern struct {
 	Matches [][]AST
 	Bodies  []AST
 	Envs    []*Environment
+
+	Pos Position
+
+	// Arms of the unapplied pattern remaining in Matches, nil before the
+	// first application
+	index *armIndex
+	arms  []int
 }
 
 func NewPattern(matchGroups [][]AST, bodies []AST) (Pattern, error) {
@@ -110,26 +133,35 @@ func NewPattern(matchGroups [][]AST, bodies []AST) (Pattern, error) {
 		matchGroups,
 		bodies,
 		[]*Environment{},
+		Position{},
+		&armIndex{},
+		nil,
 	}, nil
 }
 
 type Identifier struct {
 	Value string
+
+	Pos Position
 }
 
 func NewIdentifier(v string) (Identifier, error) {
 	return Identifier{
 		v,
+		Position{},
 	}, nil
 }
 
 type Label struct {
 	Value string
+
+	Pos Position
 }
 
 func NewLabel(v string) (Label, error) {
 	return Label{
 		v,
+		Position{},
 	}, nil
 }
 
@@ -138,30 +170,53 @@ var False, _ = NewLabel("false")
 
 type String struct {
 	Value string
+
+	Pos Position
 }
 
 func NewString(v string) (String, error) {
 	return String{
 		v,
+		Position{},
 	}, nil
 }
 
 type List struct {
 	Values []AST
+
+	Pos Position
 }
 
 type ListConstructor struct {
 	Head AST
 	Tail AST
+
+	Pos Position
 }
 
 type Number struct {
 	Value int
+
+	Pos Position
 }
 
 func NewNumber(v int) (Number, error) {
 	return Number{
 		v,
+		Position{},
+	}, nil
+}
+
+type Decimal struct {
+	Value float64
+
+	Pos Position
+}
+
+func NewDecimal(v float64) (Decimal, error) {
+	return Decimal{
+		v,
+		Position{},
 	}, nil
 }
 
@@ -169,6 +224,8 @@ type Let struct {
 	BoundIds    []Identifier
 	BoundValues []AST
 	Body        AST
+
+	Pos Position
 }
 
 func (l *Let) Bind(i Identifier, v AST) {
@@ -181,6 +238,7 @@ func NewLet(ids []Identifier, vs []AST, b AST) (Let, error) {
 		ids,
 		vs,
 		b,
+		Position{},
 	}, nil
 }
 
@@ -188,6 +246,8 @@ type Where struct {
 	Match        AST
 	Condition    AST
 	ConstantTime bool
+
+	Pos Position
 }
 
 func NewWhere(m AST, c AST, ct bool) (Where, error) {
@@ -195,6 +255,7 @@ func NewWhere(m AST, c AST, ct bool) (Where, error) {
 		m,
 		c,
 		ct,
+		Position{},
 	}, nil
 }
 
@@ -282,7 +343,34 @@ func (e Label) String() []string {
 }
 
 func (e String) String() []string {
-	return []string{fmt.Sprintf(`"%s"`, e.Value)}
+	return []string{quote(e.Value)}
+}
+
+// Writes a string as a slang string literal, escaping what needs to be
+func quote(s string) string {
+	res := `"`
+
+	for _, c := range s {
+		switch c {
+		case '\n':
+			res += `\n`
+		case '\t':
+			res += `\t`
+		case '\r':
+			res += `\r`
+		case '"', '\\', '{', '}':
+			res += `\` + string(c)
+
+		default:
+			if unicode.IsPrint(c) {
+				res += string(c)
+			} else {
+				res += fmt.Sprintf(`\u{%x}`, c)
+			}
+		}
+	}
+
+	return res + `"`
 }
 
 func (e List) String() []string {
@@ -331,6 +419,17 @@ func (e Number) String() []string {
 	return []string{fmt.Sprintf("%d", e.Value)}
 }
 
+func (e Decimal) String() []string {
+	str := strconv.FormatFloat(e.Value, 'f', -1, 64)
+
+	// Whole decimals keep their point so they read back as decimals
+	if !strings.ContainsAny(str, ".NI") {
+		str += ".0"
+	}
+
+	return []string{str}
+}
+
 func (e Let) String() []string {
 	res := []string{}
 
@@ -442,6 +541,19 @@ func (A Number) Equals(b interface{}) bool {
 	switch B := b.(type) {
 	case Number:
 		return A.Value == B.Value
+	case Decimal:
+		return float64(A.Value) == B.Value
+	}
+
+	return false
+}
+
+func (A Decimal) Equals(b interface{}) bool {
+	switch B := b.(type) {
+	case Decimal:
+		return A.Value == B.Value
+	case Number:
+		return A.Value == float64(B.Value)
 	}
 
 	return false
@@ -471,13 +583,13 @@ func (A Where) Equals(b interface{}) bool {
 }
 
 func (A Builtin) Equals(b interface{}) bool {
-	panic("TODO")
+	return false
 }
 
 // -- Copy --------------------------
 
 func (a Application) Copy() AST {
-	res := Application{}
+	res := Application{Pos: a.Pos}
 
 	for _, ast := range a.Body {
 		res.Body = append(res.Body, ast.Copy())
@@ -487,7 +599,7 @@ func (a Application) Copy() AST {
 }
 
 func (a Pattern) Copy() AST {
-	res := Pattern{}
+	res := Pattern{Pos: a.Pos, index: &armIndex{}}
 
 	for _, matchGroup := range a.Matches {
 		matchGroupCopy := []AST{}
@@ -515,11 +627,11 @@ func (a Label) Copy() AST {
 }
 
 func (a String) Copy() AST {
-	return String{"" + a.Value}
+	return String{"" + a.Value, a.Pos}
 }
 
 func (a List) Copy() AST {
-	res := List{}
+	res := List{Pos: a.Pos}
 
 	for _, ast := range a.Values {
 		res.Values = append(res.Values, ast.Copy())
@@ -532,6 +644,7 @@ func (a ListConstructor) Copy() AST {
 	return ListConstructor{
 		a.Head.Copy(),
 		a.Tail.Copy(),
+		a.Pos,
 	}
 }
 
@@ -539,8 +652,12 @@ func (a Number) Copy() AST {
 	return a
 }
 
+func (a Decimal) Copy() AST {
+	return a
+}
+
 func (a Let) Copy() AST {
-	res := Let{}
+	res := Let{Pos: a.Pos}
 
 	for _, id := range a.BoundIds {
 		res.BoundIds = append(res.BoundIds, id.Copy().(Identifier))
@@ -550,7 +667,7 @@ func (a Let) Copy() AST {
 		res.BoundValues = append(res.BoundValues, ast.Copy())
 	}
 
-	if res.Body != nil {
+	if a.Body != nil {
 		res.Body = a.Body.Copy()
 	}
 
@@ -562,6 +679,7 @@ func (a Where) Copy() AST {
 		a.Match.Copy(),
 		a.Condition.Copy(),
 		a.ConstantTime,
+		a.Pos,
 	}
 }
 
@@ -574,6 +692,7 @@ func (a Builtin) Copy() AST {
 type RuntimeError struct {
 	wraps   error
 	message string
+	pos     Position
 }
 
 func NewRuntimeError(wrapped error, message string) RuntimeError {
@@ -583,39 +702,134 @@ func NewRuntimeError(wrapped error, message string) RuntimeError {
 	}
 }
 
+// Runtime errors raised while evaluating a node make up the slang stack trace
+func NewRuntimeErrorAt(pos Position, wrapped error, message string) RuntimeError {
+	return RuntimeError{
+		wraps:   wrapped,
+		message: message,
+		pos:     pos,
+	}
+}
+
 func (r RuntimeError) Unwrap() error {
 	return r.wraps
 }
 
+func (r RuntimeError) Position() Position {
+	return r.pos
+}
+
 func (r RuntimeError) Error() string {
 	messages := []string{}
+	cause := ""
+	var innermost *RuntimeError
 
 	var err error = r
 
 	for ; err != nil; err = errors.Unwrap(err) {
 		if rerr, ok := err.(RuntimeError); ok {
-			messages = append(messages, rerr.message)
+			if rerr.pos.IsValid() {
+				messages = append(messages, fmt.Sprintf("%s %s", rerr.pos, rerr.message))
+				innermost = &rerr
+			} else {
+				messages = append(messages, rerr.message)
+			}
+
+			cause = rerr.message
 		} else {
 			messages = append(messages, err.Error())
+			cause = err.Error()
 		}
 	}
 
 	message := "RUNTIME ERROR:\n"
+	frames := []string{}
 
 	for i := len(messages) - 1; i >= 0; i-- {
-		message += fmt.Sprintf(" > %s\n", messages[i])
+		frames = append(frames, messages[i])
+	}
+
+	for _, line := range collapseFrames(frames) {
+		message += line + "\n"
+	}
+
+	if innermost != nil {
+		if lines := sourceLines(innermost.pos); lines != nil {
+			message += sourceDisplay(innermost.pos.Line, innermost.pos.Char, lines, cause)
+		}
 	}
 
 	return message
 }
 
+// Longest run of frames collapsed when recursion repeats it
+const maxRepeatedFrames = 8
+
+// Deep recursion repeats the same frames, or the same few frames when it
+// isn't direct, collapse each run into one copy
+func collapseFrames(frames []string) []string {
+	res := []string{}
+
+	for i := 0; i < len(frames); {
+		size, repeats := 1, 0
+
+		for n := 1; n <= maxRepeatedFrames && i+2*n <= len(frames); n++ {
+			r := 0
+
+			for start := i + n; start+n <= len(frames) && equalFrames(frames[i:i+n], frames[start:start+n]); start += n {
+				r++
+			}
+
+			if r*n > repeats*size {
+				size, repeats = n, r
+			}
+		}
+
+		for _, frame := range frames[i : i+size] {
+			res = append(res, " > "+frame)
+		}
+
+		switch {
+		case repeats > 0 && size == 1:
+			res = append(res, fmt.Sprintf("   ... repeated %d more times", repeats))
+		case repeats > 0:
+			res = append(res, fmt.Sprintf("   ... last %d frames repeated %d more times", size, repeats))
+		}
+
+		i += size * (repeats + 1)
+	}
+
+	return res
+}
+
+func equalFrames(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
 type Environment struct {
 	parent *Environment
 	bound  map[string]AST
+
+	// Shared by the environments of one evaluation
+	budget  *Budget
+	effects *Effects
 }
 
 func NewEnv(parent *Environment) *Environment {
-	return &Environment{parent, map[string]AST{}}
+	env := &Environment{parent, map[string]AST{}, nil, nil}
+
+	if parent != nil {
+		env.budget = parent.budget
+		env.effects = parent.effects
+	}
+
+	return env
 }
 
 func (e *Environment) HasDef() map[string]bool {
@@ -654,33 +868,104 @@ func (e *Environment) Get(key string) (AST, bool) {
 
 // -- EVAL ------------------------------
 
+// Names the applied value for stack traces
+func (a Application) describe() string {
+	return describeApplied(a.Body[0])
+}
+
+func describeApplied(fn AST) string {
+	switch A := fn.(type) {
+	case Identifier:
+		return fmt.Sprintf("in application of %s", A.Value)
+	case Pattern:
+		return "in application of pattern"
+	}
+
+	return "in application"
+}
+
 func (a Application) Eval(env *Environment) (AST, error) {
+	budget := env.Budget()
+	err := budget.Enter()
+	defer budget.Leave()
+
+	var res AST
+	var call *tailCall
+
+	if err == nil {
+		res, call, err = a.evalTail(env)
+	}
+
+	if err != nil {
+		return nil, NewRuntimeErrorAt(a.Pos, err, a.describe())
+	}
+
+	// The tail call is made from this application, the trampoline keeps its
+	// frame in traces
+	return trampoline(res, call)
+}
+
+// Evaluates all but the final application of a pattern, which is returned as a
+// tail call
+func (a Application) evalTail(env *Environment) (AST, *tailCall, error) {
+	if op, ok := a.Body[0].(Identifier); ok && len(a.Body) == 3 {
+		if _, ok := logicalOps[op.Value]; ok {
+			res, err := evalLogical(op.Value, a.Body[1], a.Body[2], env)
+
+			return res, nil, err
+		}
+	}
+
+	TraceApplication(env, a.Pos, a.describe())
+
 	res, err := a.Body[0].Eval(env)
 
 	if err != nil {
-		return nil, NewRuntimeError(err, "Unable to evaluate for application")
+		return nil, nil, err
 	}
 
-	for _, argAst := range a.Body[1:] {
+	args := a.Body[1:]
+
+	for i, argAst := range args {
 		arg, err := argAst.Eval(env)
 
 		if err != nil {
-			return nil, NewRuntimeError(err, "Unable to evaluate for application")
+			return nil, nil, err
+		}
+
+		if err := env.Budget().Step(); err != nil {
+			return nil, nil, err
 		}
 
-		res, err = res.Apply(arg)
+		if pattern, ok := res.(Pattern); ok && i == len(args)-1 {
+			res, call, err := pattern.apply(arg, env)
+
+			if call != nil {
+				call.from = tailFrame{pos: a.Pos, fn: a.Body[0]}
+			}
+
+			return res, call, err
+		}
+
+		res, err = ApplyIn(res, arg, env)
 
 		if err != nil {
-			return nil, NewRuntimeError(err, "Unable to apply for application")
+			return nil, nil, err
 		}
 	}
 
-	return res, nil
+	return res, nil, nil
 }
 
 func (a Pattern) Eval(env *Environment) (AST, error) {
+	if a.index == nil {
+		a.index = &armIndex{}
+	}
+
+	// Arms only get their own environment once a value is matched against
+	// them
 	for range a.Bodies {
-		a.Envs = append(a.Envs, NewEnv(env))
+		a.Envs = append(a.Envs, env)
 	}
 
 	return a, nil
@@ -691,20 +976,25 @@ func (a Identifier) Eval(env *Environment) (AST, error) {
 		return v, nil
 	}
 
-	return nil, NewRuntimeError(nil, fmt.Sprintf("Cannot get value for identifier '%s'", a.Value))
+	return nil, NewRuntimeErrorAt(a.Pos, nil, fmt.Sprintf("Cannot get value for identifier '%s'", a.Value))
 }
 
-func (a Number) Eval(*Environment) (AST, error) { return a, nil }
-func (a Label) Eval(*Environment) (AST, error)  { return a, nil }
-func (a String) Eval(*Environment) (AST, error) { return a, nil }
+func (a Number) Eval(*Environment) (AST, error)  { return a, nil }
+func (a Decimal) Eval(*Environment) (AST, error) { return a, nil }
+func (a Label) Eval(*Environment) (AST, error)   { return a, nil }
+func (a String) Eval(*Environment) (AST, error)  { return a, nil }
 func (a List) Eval(env *Environment) (AST, error) {
+	if err := env.Budget().Allocate(len(a.Values)); err != nil {
+		return nil, NewRuntimeErrorAt(a.Pos, err, "in list")
+	}
+
 	res := List{}
 
 	for _, valAst := range a.Values {
 		val, err := valAst.Eval(env)
 
 		if err != nil {
-			return nil, NewRuntimeError(err, "Unable to evaluate for list")
+			return nil, NewRuntimeErrorAt(a.Pos, err, "in list")
 		}
 
 		res.Values = append(res.Values, val)
@@ -712,100 +1002,333 @@ func (a List) Eval(env *Environment) (AST, error) {
 
 	return res, nil
 }
-func (a ListConstructor) Eval(*Environment) (AST, error) { panic("TODO eval list con") }
+func (a ListConstructor) Eval(env *Environment) (AST, error) {
+	head, err := a.Head.Eval(env)
+
+	if err != nil {
+		return nil, NewRuntimeErrorAt(a.Pos, err, "in list constructor head")
+	}
+
+	tail, err := a.Tail.Eval(env)
+
+	if err != nil {
+		return nil, NewRuntimeErrorAt(a.Pos, err, "in list constructor tail")
+	}
+
+	if err := env.Budget().Allocate(Allocation(tail) + 1); err != nil {
+		return nil, NewRuntimeErrorAt(a.Pos, err, "in list constructor")
+	}
+
+	switch T := tail.(type) {
+	case List:
+		return List{Values: append([]AST{head}, T.Values...)}, nil
+
+	case String:
+		if H, ok := head.(String); ok && IsCharacter(H) {
+			return String{Value: H.Value + T.Value}, nil
+		}
+
+		return nil, NewRuntimeErrorAt(a.Pos, nil, "List constructor with a string tail must have a single character string head")
+	}
+
+	return nil, NewRuntimeErrorAt(a.Pos, nil, "List constructor tail must be a list or string")
+}
+
+// Strings are lists of characters to list constructors, a character being a
+// single rune
+func IsCharacter(s String) bool {
+	return utf8.RuneCountInString(s.Value) == 1
+}
+
+// Splits a non empty string into its first character and the rest
+func SplitString(s String) (String, String) {
+	_, size := utf8.DecodeRuneInString(s.Value)
+
+	return String{Value: s.Value[:size]}, String{Value: s.Value[size:]}
+}
+
 func (a Let) Eval(env *Environment) (AST, error) {
+	res, call, err := a.evalTail(env)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return trampoline(res, call)
+}
+
+func (a Let) evalTail(env *Environment) (AST, *tailCall, error) {
 	env = NewEnv(env)
 
 	for i, id := range a.BoundIds {
 		val, err := a.BoundValues[i].Eval(env)
 
 		if err != nil {
-			return nil, NewRuntimeError(err, "Unable to evaluate for let")
+			return nil, nil, NewRuntimeErrorAt(id.Pos, err, fmt.Sprintf("in binding of %s", id.Value))
 		}
 
 		env.Set(id.Value, val)
 	}
 
-	return a.Body.Eval(env)
+	return evalTail(a.Body, env)
 }
-func (a Where) Eval(*Environment) (AST, error)   { panic("TODO eval where") }
+
+// Outside of a match a where guards its value, which is only produced when the
+// condition holds
+func (a Where) Eval(env *Environment) (AST, error) {
+	if a.ConstantTime {
+		if err := checkConstantTime(a.Condition, env); err != nil {
+			return nil, NewRuntimeErrorAt(a.Pos, err, "in constant time guard")
+		}
+	}
+
+	cond, err := a.Condition.Eval(env)
+
+	if err != nil {
+		return nil, NewRuntimeErrorAt(a.Pos, err, "in where condition")
+	}
+
+	if !cond.Equals(True) {
+		return nil, NewRuntimeErrorAt(a.Pos, nil, "Where condition does not hold")
+	}
+
+	return a.Match.Eval(env)
+}
+
 func (a Builtin) Eval(*Environment) (AST, error) { return a, nil }
 
 // -- APPLY -----------------------------
 
-func (a Application) Apply(b AST) (AST, error) { panic("TODO apply app") }
-func (a Identifier) Apply(b AST) (AST, error)  { panic("TODO apply id") }
+func (a Application) Apply(b AST) (AST, error) {
+	return nil, NewRuntimeError(nil, "Cannot apply value to unevaluated application")
+}
+func (a Identifier) Apply(b AST) (AST, error) {
+	return nil, NewRuntimeError(nil, fmt.Sprintf("Cannot apply value to unevaluated identifier '%s'", a.Value))
+}
 func (a Label) Apply(b AST) (AST, error) {
 	return nil, NewRuntimeError(nil, "Cannot apply value to label")
 }
 func (a String) Apply(b AST) (AST, error) {
 	return nil, NewRuntimeError(nil, "Cannot apply value to string")
 }
-func (a List) Apply(b AST) (AST, error)            {
+func (a List) Apply(b AST) (AST, error) {
 	return nil, NewRuntimeError(nil, "Cannot apply value to list")
 }
-func (a ListConstructor) Apply(b AST) (AST, error) { panic("TODO apply list con") }
-func (a Number) Apply(b AST) (AST, error)          { panic("TODO apply num") }
-func (a Let) Apply(b AST) (AST, error)             { panic("TODO apply let") }
-func (a Where) Apply(b AST) (AST, error)           { panic("TODO apply where") }
+func (a ListConstructor) Apply(b AST) (AST, error) {
+	return nil, NewRuntimeError(nil, "Cannot apply value to list constructor")
+}
+func (a Number) Apply(b AST) (AST, error) {
+	return nil, NewRuntimeError(nil, "Cannot apply value to number")
+}
+func (a Decimal) Apply(b AST) (AST, error) {
+	return nil, NewRuntimeError(nil, "Cannot apply value to decimal")
+}
+func (a Let) Apply(b AST) (AST, error) {
+	return nil, NewRuntimeError(nil, "Cannot apply value to unevaluated let")
+}
+func (a Where) Apply(b AST) (AST, error) {
+	return nil, NewRuntimeError(nil, "Cannot apply value to where")
+}
 func (a Builtin) Apply(b AST) (AST, error) {
 	return a.apply(b, nil)
 }
 
 func (a Pattern) Apply(val AST) (AST, error) {
-	res, _ := NewPattern([][]AST{}, []AST{})
+	res, call, err := a.apply(val, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return trampoline(res, call)
+}
 
-	for i, matchGroup := range a.Matches {
-		env := NewEnv(a.Envs[i])
+// Applies a value from an environment, so patterns and builtins run with its
+// budget and effects rather than those of the environment they were defined
+// in
+func ApplyIn(fn AST, val AST, env *Environment) (AST, error) {
+	switch F := fn.(type) {
+	case Pattern:
+		res, call, err := F.apply(val, env)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return trampoline(res, call)
+
+	case Builtin:
+		return F.apply(val, env)
+	}
 
-		if patternMatch(env, a, res, matchGroup[0], val) {
+	return fn.Apply(val)
+}
+
+// Applies a value to the pattern, once all matches are satisfied the body is
+// returned as a tail call rather than evaluated. Arms run with the budget and
+// effects of the applying environment when there is one.
+func (a Pattern) apply(val AST, from *Environment) (AST, *tailCall, error) {
+	res := Pattern{Pos: a.Pos, index: a.index}
+
+	if a.index == nil {
+		return nil, nil, NewRuntimeErrorAt(a.Pos, nil, "Cannot apply value to unevaluated pattern")
+	}
+
+	if len(a.Matches) == 0 {
+		return nil, nil, NewRuntimeErrorAt(a.Pos, nil, "Pattern has no arms to match against")
+	}
+
+	if a.arms == nil {
+		a.index.build(a)
+	}
+
+	column := a.index.columns[len(a.index.columns)-len(a.Matches[0])]
+	candidates := column.candidates(val)
+	var filtered filterResults
+
+	if len(column.filters) > 0 {
+		filtered = filterResults{}
+	}
+
+	res.Matches = make([][]AST, 0, len(candidates))
+	res.Bodies = make([]AST, 0, len(candidates))
+	res.Envs = make([]*Environment, 0, len(candidates))
+	res.arms = make([]int, 0, len(candidates))
+
+	// Only the candidates are visited, and an arm only gets an environment
+	// once its guard filter passes
+	for _, arm := range candidates {
+		i, ok := a.remaining(arm)
+
+		if !ok {
+			continue
+		}
+
+		matchGroup := a.Matches[i]
+		var env *Environment
+
+		if filter, ok := column.filters[arm]; ok {
+			passed, tested, err := filter.test(filtered, a.Envs[i], from, val)
+
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if tested && !passed {
+				continue
+			}
+
+			// A passing filter leaves only the identifier to bind
+			if tested {
+				env = armEnv(a.Envs[i], from)
+				env.Set(filter.match.Value, val)
+			}
+		}
+
+		matched := env != nil
+
+		if env == nil {
+			var err error
+			env = armEnv(a.Envs[i], from)
+			matched, err = patternMatch(env, a, res, matchGroup[0], val)
+
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		if matched {
 			res.Matches = append(res.Matches, matchGroup[1:])
 			res.Bodies = append(res.Bodies, a.Bodies[i])
 			res.Envs = append(res.Envs, env)
+			res.arms = append(res.arms, arm)
 		}
 	}
 
 	if len(res.Matches) == 0 {
-		fmt.Println("---------------")
-		Print(a)
-		Print(val)
-		fmt.Println("---------------")
-
-		return nil, NewRuntimeError(nil, "Unable to match any values to pattern")
+		return nil, nil, NewRuntimeErrorAt(a.Pos, nil, "Unable to match any values to pattern")
 	}
 
 	if len(res.Matches[0]) == 0 {
-		return res.Bodies[0].Eval(res.Envs[0])
+		return nil, &tailCall{body: res.Bodies[0], env: res.Envs[0]}, nil
 	}
 
-	return res, nil
+	return res, nil, nil
+}
+
+// Where an arm of the unapplied pattern is in Matches, if it still is
+func (a Pattern) remaining(arm int) (int, bool) {
+	if a.arms == nil {
+		return arm, true
+	}
+
+	i := sort.SearchInts(a.arms, arm)
+
+	return i, i < len(a.arms) && a.arms[i] == arm
 }
 
-func patternMatch(env *Environment, ast Pattern, res Pattern, m AST, val AST) bool {
+// The environment an arm is matched in, with the budget and effects of the
+// applying environment when there is one
+func armEnv(parent *Environment, from *Environment) *Environment {
+	env := NewEnv(parent)
+
+	if from != nil {
+		env.budget = from.budget
+		env.effects = from.effects
+	}
+
+	return env
+}
+
+// Only constant time guards report errors, other guards that fail to
+// evaluate just don't match unless a limit was reached
+func patternMatch(env *Environment, ast Pattern, res Pattern, m AST, val AST) (bool, error) {
 	switch match := m.(type) {
 	case Where:
-		if patternMatch(env, ast, res, match.Match, val) {
-			res, err := match.Condition.Eval(env)
+		matched, err := patternMatch(env, ast, res, match.Match, val)
+
+		if !matched || err != nil {
+			return false, err
+		}
+
+		if match.ConstantTime {
+			if err := checkConstantTime(match.Condition, env); err != nil {
+				return false, NewRuntimeErrorAt(match.Pos, err, "in constant time guard")
+			}
+
+			cond, err := match.Condition.Eval(env)
+
+			if err != nil {
+				return false, NewRuntimeErrorAt(match.Pos, err, "in constant time guard")
+			}
 
-			return err == nil && res.Equals(Label{Value: "true"})
+			return cond.Equals(True), nil
 		}
 
+		cond, err := match.Condition.Eval(env)
+
+		if IsLimitError(err) {
+			return false, err
+		}
+
+		return err == nil && cond.Equals(Label{Value: "true"}), nil
+
 	case List:
 		switch V := val.(type) {
 		case List:
 			if len(match.Values) == len(V.Values) {
 				for i := range V.Values {
-					if !patternMatch(env, ast, res, match.Values[i], V.Values[i]) {
-						return false
+					if matched, err := patternMatch(env, ast, res, match.Values[i], V.Values[i]); !matched || err != nil {
+						return false, err
 					}
 				}
 
-				return true
+				return true, nil
 			}
 
 		case String:
 			if len(match.Values) == 0 && len(V.Value) == 0 {
-				return true
+				return true, nil
 			}
 
 			// NOTE: can we do better comparisons?
@@ -815,29 +1338,39 @@ func patternMatch(env *Environment, ast Pattern, res Pattern, m AST, val AST) bo
 		// Match for a list
 		if list, ok := val.(List); ok {
 			if len(list.Values) > 0 {
-				return patternMatch(env, ast, res, match.Head, list.Values[0]) && patternMatch(env, ast, res, match.Tail, List{list.Values[1:]})
+				return matchBoth(env, ast, res, match, list.Values[0], List{Values: list.Values[1:]})
 			}
 		}
 
 		// Match for a string
 		if str, ok := val.(String); ok {
 			if len(str.Value) > 0 {
-				return patternMatch(env, ast, res, match.Head, String{string(str.Value[0])}) && patternMatch(env, ast, res, match.Tail, String{str.Value[1:]})
+				head, tail := SplitString(str)
+
+				return matchBoth(env, ast, res, match, head, tail)
 			}
 		}
 
 	case Identifier:
 		if v, ok := env.Get(match.Value); ok {
-			return v.Equals(val)
+			return v.Equals(val), nil
 		} else {
 			env.Set(match.Value, val)
 
-			return true
+			return true, nil
 		}
 
 	default:
-		return match.Equals(val)
+		return match.Equals(val), nil
 	}
 
-	return false
+	return false, nil
+}
+
+func matchBoth(env *Environment, ast Pattern, res Pattern, match ListConstructor, head AST, tail AST) (bool, error) {
+	if matched, err := patternMatch(env, ast, res, match.Head, head); !matched || err != nil {
+		return false, err
+	}
+
+	return patternMatch(env, ast, res, match.Tail, tail)
 }
@@ -0,0 +1,30 @@
+package ast
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCollapseFrames(t *testing.T) {
+	frames := []string{"error", "a"}
+
+	for i := 0; i < 100; i++ {
+		frames = append(frames, "f", "+")
+	}
+
+	frames = append(frames, "main", "main", "main")
+
+	expected := []string{
+		" > error",
+		" > a",
+		" > f",
+		" > +",
+		"   ... last 2 frames repeated 99 more times",
+		" > main",
+		"   ... repeated 2 more times",
+	}
+
+	if res := collapseFrames(frames); strings.Join(res, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(res, "\n"))
+	}
+}
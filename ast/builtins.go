@@ -0,0 +1,179 @@
+package ast
+
+import (
+	"fmt"
+)
+
+// A set of builtins bound for programs. Each interpreter has its own, so
+// builtins registered by one host program aren't seen by another.
+type Builtins struct {
+	builtins []Builtin
+}
+
+func NewBuiltins() *Builtins {
+	return &Builtins{[]Builtin{}}
+}
+
+// The builtins every program starts with
+func DefaultBuiltins() *Builtins {
+	return &Builtins{append([]Builtin{}, libFns...)}
+}
+
+// Registers a builtin taking a number of arguments, replacing any builtin
+// already registered under the name
+func (b *Builtins) Register(name string, arity int, fn func(Args) (AST, error)) error {
+	if arity < 1 {
+		return NewRuntimeError(nil, fmt.Sprintf("Builtin '%s' must take at least one argument", name))
+	}
+
+	if _, ok := logicalOps[name]; ok || name == "_" {
+		return NewRuntimeError(nil, fmt.Sprintf("Can't register a builtin named '%s'", name))
+	}
+
+	builtin := NewBuiltin(name, arity, fn)
+
+	for i, other := range b.builtins {
+		if other.name == name {
+			b.builtins[i] = builtin
+			return nil
+		}
+	}
+
+	b.builtins = append(b.builtins, builtin)
+
+	return nil
+}
+
+// Binds every builtin, a program is evaluated as the body
+func (b *Builtins) Let() Let {
+	let, _ := NewLet([]Identifier{}, []AST{}, nil)
+
+	for _, builtin := range b.builtins {
+		let.Bind(Identifier{Value: builtin.name}, builtin)
+	}
+
+	let.Body = Identifier{Value: "NO BODY"}
+
+	return let
+}
+
+// Curries a function of many arguments, so the builtin is applied to one
+// argument at a time and the function called once all have been applied
+func NewBuiltin(name string, arity int, fn func(Args) (AST, error)) Builtin {
+	return curry(name, name, arity, []AST{}, fn)
+}
+
+func curry(name string, display string, arity int, applied []AST, fn func(Args) (AST, error)) Builtin {
+	return Builtin{
+		display,
+		func(a AST, env *Environment) (AST, error) {
+			args := append(applied[:len(applied):len(applied)], a)
+
+			if len(args) < arity {
+				return curry(name, name+" curried", arity, args, fn), nil
+			}
+
+			return fn(Args{name, args, env})
+		},
+	}
+}
+
+// Arguments a builtin was applied to, with accessors decoding them into Go
+// values
+type Args struct {
+	name   string
+	values []AST
+	env    *Environment
+}
+
+func (a Args) Len() int {
+	return len(a.values)
+}
+
+func (a Args) Value(i int) AST {
+	return a.values[i]
+}
+
+func (a Args) Int(i int) (int, error) {
+	if N, ok := a.values[i].(Number); ok {
+		return N.Value, nil
+	}
+
+	return 0, a.expected(i, "a number")
+}
+
+// Numbers are promoted to decimals
+func (a Args) Float(i int) (float64, error) {
+	switch V := a.values[i].(type) {
+	case Number:
+		return float64(V.Value), nil
+	case Decimal:
+		return V.Value, nil
+	}
+
+	return 0, a.expected(i, "a number or decimal")
+}
+
+func (a Args) String(i int) (string, error) {
+	if S, ok := a.values[i].(String); ok {
+		return S.Value, nil
+	}
+
+	return "", a.expected(i, "a string")
+}
+
+func (a Args) Label(i int) (string, error) {
+	if L, ok := a.values[i].(Label); ok {
+		return L.Value, nil
+	}
+
+	return "", a.expected(i, "a label")
+}
+
+func (a Args) Bool(i int) (bool, error) {
+	if isBool(a.values[i]) {
+		return a.values[i].Equals(True), nil
+	}
+
+	return false, a.expected(i, "a boolean")
+}
+
+func (a Args) List(i int) ([]AST, error) {
+	if L, ok := a.values[i].(List); ok {
+		return L.Values, nil
+	}
+
+	return nil, a.expected(i, "a list")
+}
+
+func (a Args) expected(i int, kind string) error {
+	return NewRuntimeError(nil, fmt.Sprintf("'%s' expects %s as argument %d, found %s", a.name, kind, i+1, describeValue(a.values[i])))
+}
+
+// Names the kind of a value, along with the value when it's short
+func describeValue(v AST) string {
+	kind := "a value"
+
+	switch v.(type) {
+	case Number:
+		kind = "a number"
+	case Decimal:
+		kind = "a decimal"
+	case String:
+		kind = "a string"
+	case Label:
+		kind = "a label"
+	case List:
+		kind = "a list"
+	case Pattern:
+		return "a pattern"
+	case Builtin:
+		return "a builtin"
+	}
+
+	if strs := v.String(); len(strs) == 1 && len(strs[0]) <= 32 {
+		return kind + " " + strs[0]
+	}
+
+	return kind
+}
@@ -0,0 +1,80 @@
+package ast
+
+import (
+	"fmt"
+)
+
+// Constant time guards, written with `::`, may only apply builtins to values.
+// They can't recurse or call back into user patterns, so testing one costs a
+// bounded amount of work no matter what the rest of the program does.
+
+// Builtins with effects can't be applied by constant time guards
+var effectfulBuiltins = map[string]bool{
+	"print": true,
+}
+
+// Whether a constant time guard may apply the value
+func IsConstantTime(v AST) bool {
+	if B, ok := v.(Builtin); ok {
+		return !effectfulBuiltins[B.name]
+	}
+
+	return false
+}
+
+// Checks that a guard only applies builtins. Without an environment only the
+// shape of the guard is checked, as when it is parsed and the builtins it will
+// run with aren't known yet. With one every applied identifier must be bound
+// to a builtin, so registered builtins are allowed and patterns, including
+// ones shadowing a builtin, are caught.
+func checkConstantTime(a AST, env *Environment) error {
+	switch A := a.(type) {
+	case Application:
+		id, ok := A.Body[0].(Identifier)
+
+		if !ok {
+			return NewRuntimeErrorAt(A.Pos, nil, "Constant time guards may only apply builtins")
+		}
+
+		_, isLogical := logicalOps[id.Value]
+		notBuiltin := NewRuntimeErrorAt(A.Pos, nil, fmt.Sprintf("Constant time guards may only apply builtins, '%s' is not one", id.Value))
+
+		if effectfulBuiltins[id.Value] {
+			return notBuiltin
+		}
+
+		if env != nil && !isLogical {
+			if v, ok := env.Get(id.Value); !ok || !IsConstantTime(v) {
+				return notBuiltin
+			}
+		}
+
+		for _, arg := range A.Body[1:] {
+			if err := checkConstantTime(arg, env); err != nil {
+				return err
+			}
+		}
+
+	case List:
+		for _, v := range A.Values {
+			if err := checkConstantTime(v, env); err != nil {
+				return err
+			}
+		}
+
+	case ListConstructor:
+		if err := checkConstantTime(A.Head, env); err != nil {
+			return err
+		}
+
+		return checkConstantTime(A.Tail, env)
+
+	case Pattern:
+		return NewRuntimeErrorAt(A.Pos, nil, "Constant time guards can't contain patterns")
+
+	case Let:
+		return NewRuntimeErrorAt(A.Pos, nil, "Constant time guards can't bind values")
+	}
+
+	return nil
+}
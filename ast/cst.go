@@ -0,0 +1,209 @@
+package ast
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// The concrete syntax tree of a source file, as parsed before Lower turns it
+// into the nodes that are evaluated. Nothing is dropped: every token is kept
+// with the whitespace and comments before it, and sugar like `match`,
+// `module`, operators and `=>` keeps its own node, so printing the tokens in
+// order gives back the source exactly.
+type Node struct {
+	Kind int
+
+	// Set on token leaves, which have no children
+	Token *Token
+
+	Children []*Node
+}
+
+const (
+	_ = iota
+
+	NODE_KIND_TOKEN
+
+	// package name, imports, the expression and the end of the file
+	NODE_KIND_FILE
+	NODE_KIND_IMPORT
+
+	// id = value, followed by the body
+	NODE_KIND_LET
+
+	// Values applied to each other, and binary operators
+	NODE_KIND_APPLICATION
+	NODE_KIND_OPERATION
+	NODE_KIND_PARENS
+
+	NODE_KIND_PATTERN
+	NODE_KIND_ARM
+	NODE_KIND_DEFAULT_ARM
+
+	// Matches with no arrow or body, matching is .true
+	NODE_KIND_IMPLICIT_ARM
+	NODE_KIND_WHERE
+
+	NODE_KIND_MATCH
+	NODE_KIND_MODULE
+	NODE_KIND_BINDING
+
+	NODE_KIND_LIST
+	NODE_KIND_LIST_CONSTRUCTOR
+
+	NODE_KIND_IDENTIFIER
+	NODE_KIND_LABEL
+	NODE_KIND_STRING
+	NODE_KIND_NUMBER
+	NODE_KIND_DECIMAL
+
+	// Tokens skipped after an error, up to where parsing recovered
+	NODE_KIND_ERROR
+)
+
+var nodeKindNames = []string{
+	"",
+	"token",
+	"file",
+	"import",
+	"let",
+	"application",
+	"operation",
+	"parens",
+	"pattern",
+	"arm",
+	"default arm",
+	"implicit arm",
+	"where",
+	"match",
+	"module",
+	"binding",
+	"list",
+	"list constructor",
+	"identifier",
+	"label",
+	"string",
+	"number",
+	"decimal",
+	"error",
+}
+
+func newNode(kind int, children ...*Node) *Node {
+	return &Node{kind, nil, children}
+}
+
+func (n *Node) add(children ...*Node) {
+	n.Children = append(n.Children, children...)
+}
+
+// Every token under the node, in source order
+func (n *Node) Tokens() []*Token {
+	if n.Token != nil {
+		return []*Token{n.Token}
+	}
+
+	res := []*Token{}
+
+	for _, child := range n.Children {
+		res = append(res, child.Tokens()...)
+	}
+
+	return res
+}
+
+// The source the node was parsed from, with the whitespace and comments
+// before its first token
+func (n *Node) Source() string {
+	res := ""
+
+	for _, t := range n.Tokens() {
+		res += t.Leading + t.Text
+	}
+
+	return res
+}
+
+// Position of the first token of the node
+func (n *Node) Pos() Position {
+	if tokens := n.Tokens(); len(tokens) > 0 {
+		return tokens[0].Pos
+	}
+
+	return Position{}
+}
+
+// Child nodes that aren't tokens
+func (n *Node) nodes() []*Node {
+	res := []*Node{}
+
+	for _, child := range n.Children {
+		if child.Token == nil {
+			res = append(res, child)
+		}
+	}
+
+	return res
+}
+
+// The first child token of a kind
+func (n *Node) token(kind int) *Token {
+	for _, child := range n.Children {
+		if child.Token != nil && child.Token.kind == kind {
+			return child.Token
+		}
+	}
+
+	return nil
+}
+
+// Prints the tree one node per line, with tokens as written and the comments
+// before them
+func (n *Node) Dump() []string {
+	res := []string{}
+	n.dump("", &res)
+
+	return res
+}
+
+func (n *Node) dump(indent string, res *[]string) {
+	if n.Token != nil {
+		line := fmt.Sprintf("%s%s %s %s", indent, n.Token.Kind, strconv.Quote(n.Token.Text), n.Token.Pos)
+
+		if comments := leadingComments(n.Token.Leading); comments != "" {
+			line += " after " + strconv.Quote(comments)
+		}
+
+		*res = append(*res, line)
+
+		return
+	}
+
+	*res = append(*res, indent+nodeKindNames[n.Kind])
+
+	for _, child := range n.Children {
+		child.dump(indent+"  ", res)
+	}
+}
+
+// Comments in whitespace, one per line
+func leadingComments(leading string) string {
+	res := ""
+	comment := -1
+
+	for i := 0; i <= len(leading); i++ {
+		switch {
+		case i < len(leading) && leading[i] == '#' && comment < 0:
+			comment = i
+
+		case comment >= 0 && (i == len(leading) || leading[i] == '\n'):
+			if res != "" {
+				res += "\n"
+			}
+
+			res += leading[comment:i]
+			comment = -1
+		}
+	}
+
+	return res
+}
@@ -136,6 +136,8 @@ func (v *DefunVisitor) Visit(a AST) (AST, error) {
 	case ListConstructor:
 	case Number:
 		return a, nil
+	case Decimal:
+		return a, nil
 	case Let:
 		return v.VisitLet(A)
 	case Where:
@@ -307,7 +309,7 @@ func MatchesThatBind(p Pattern, parentHasDef map[string]bool) (map[string]bool,
 func (v *FreeVarsVisitor) markFree(id string) {
 	if _, ok := v.freeVarsMap[id]; !ok {
 		v.freeVarsMap[id] = true
-		v.freeVarsList = append(v.freeVarsList, Identifier{id})
+		v.freeVarsList = append(v.freeVarsList, Identifier{Value: id})
 	}
 }
 
@@ -336,6 +338,8 @@ func (v *FreeVarsVisitor) Visit(a AST) error {
 	case ListConstructor:
 	case Number:
 		return nil
+	case Decimal:
+		return nil
 	case Let:
 		return v.VisitLet(A)
 	case Where:
@@ -364,6 +368,8 @@ func (v *FreeVarsVisitor) VisitMatch(a AST, onlyMatches bool) error {
 		return v.VisitListConstructorMatch(A, onlyMatches)
 	case Number:
 		return nil
+	case Decimal:
+		return nil
 	case Let:
 	case Where:
 		return v.VisitWhereMatch(A, onlyMatches)
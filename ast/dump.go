@@ -0,0 +1,103 @@
+package ast
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Prints the tree of nodes as parsed, one node per line with its position.
+// Unlike String, nothing is printed as slang source, so sugar that was
+// lowered by the parser shows up as the nodes it became.
+func Dump(a AST) []string {
+	res := []string{}
+	dump(a, "", &res)
+
+	return res
+}
+
+func dump(a AST, indent string, res *[]string) {
+	line := func(format string, args ...interface{}) {
+		*res = append(*res, indent+fmt.Sprintf(format, args...))
+	}
+
+	child := func(indent string, name string, a AST) {
+		*res = append(*res, indent+"  "+name)
+		dump(a, indent+"    ", res)
+	}
+
+	switch A := a.(type) {
+	case Application:
+		line("Application %s", A.Pos)
+
+		for _, v := range A.Body {
+			dump(v, indent+"  ", res)
+		}
+
+	case Pattern:
+		line("Pattern %s", A.Pos)
+
+		for i, matchGroup := range A.Matches {
+			*res = append(*res, indent+"  arm")
+
+			for _, match := range matchGroup {
+				child(indent+"  ", "match", match)
+			}
+
+			child(indent+"  ", "body", A.Bodies[i])
+		}
+
+	case Identifier:
+		line("Identifier %s %s", A.Value, A.Pos)
+
+	case Label:
+		line("Label .%s %s", A.Value, A.Pos)
+
+	case String:
+		line("String %s %s", strconv.Quote(A.Value), A.Pos)
+
+	case Number:
+		line("Number %d %s", A.Value, A.Pos)
+
+	case Decimal:
+		line("Decimal %s %s", strconv.FormatFloat(A.Value, 'f', -1, 64), A.Pos)
+
+	case List:
+		line("List %s", A.Pos)
+
+		for _, v := range A.Values {
+			dump(v, indent+"  ", res)
+		}
+
+	case ListConstructor:
+		line("ListConstructor %s", A.Pos)
+		child(indent, "head", A.Head)
+		child(indent, "tail", A.Tail)
+
+	case Let:
+		line("Let %s", A.Pos)
+
+		for i, id := range A.BoundIds {
+			child(indent, "bind "+id.Value, A.BoundValues[i])
+		}
+
+		if A.Body != nil {
+			child(indent, "body", A.Body)
+		}
+
+	case Where:
+		if A.ConstantTime {
+			line("Where constant time %s", A.Pos)
+		} else {
+			line("Where %s", A.Pos)
+		}
+
+		child(indent, "match", A.Match)
+		child(indent, "condition", A.Condition)
+
+	case Builtin:
+		line("Builtin %s", A.name)
+
+	default:
+		line("%T", a)
+	}
+}
@@ -0,0 +1,136 @@
+package ast
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// What builtins with effects may do. Hosts evaluate programs with their own
+// effects to sandbox or capture them, a nil capability is denied.
+type Effects struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	FS     FileSystem
+	Clock  func() time.Time
+
+	// Command line arguments of the program, without the interpreter's own
+	Args []string
+
+	// Looks up an environment variable, reporting whether it is set
+	Env func(name string) (string, bool)
+
+	// Applications are traced here as they are evaluated, when set
+	Trace io.Writer
+
+	// Stdin buffered for reading lines, created on the first read
+	lines   *bufio.Reader
+	linesOf io.Reader
+}
+
+type FileSystem interface {
+	ReadFile(path string) ([]byte, error)
+	WriteFile(path string, data []byte) error
+
+	// Names of the entries of a directory, sorted
+	ReadDir(path string) ([]string, error)
+}
+
+// The file system of the host
+type OSFileSystem struct{}
+
+func (OSFileSystem) ReadFile(path string) ([]byte, error) {
+	return ioutil.ReadFile(path)
+}
+
+func (OSFileSystem) WriteFile(path string, data []byte) error {
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+func (OSFileSystem) ReadDir(path string) ([]string, error) {
+	infos, err := ioutil.ReadDir(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	res := []string{}
+
+	for _, info := range infos {
+		res = append(res, info.Name())
+	}
+
+	return res, nil
+}
+
+// Effects on the host process, used when an environment has none
+func DefaultEffects() *Effects {
+	return &Effects{
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		FS:     OSFileSystem{},
+		Clock:  time.Now,
+		Args:   []string{},
+		Env:    os.LookupEnv,
+	}
+}
+
+// Reads the next line of stdin without its line ending, io.EOF once stdin has
+// no more lines
+func (e *Effects) ReadLine() (string, error) {
+	if e.Stdin == nil {
+		return "", NewRuntimeError(nil, "Can't read without a stdin to read from")
+	}
+
+	// Stdin may be replaced between reads
+	if e.lines == nil || e.linesOf != e.Stdin {
+		e.lines = bufio.NewReader(e.Stdin)
+		e.linesOf = e.Stdin
+	}
+
+	line, err := e.lines.ReadString('\n')
+
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	line = strings.TrimSuffix(line, "\n")
+
+	return strings.TrimSuffix(line, "\r"), nil
+}
+
+var defaultEffects = DefaultEffects()
+
+func (e *Environment) SetEffects(effects *Effects) {
+	e.effects = effects
+}
+
+func (e *Environment) Effects() *Effects {
+	if e == nil || e.effects == nil {
+		return defaultEffects
+	}
+
+	return e.effects
+}
+
+// Traces an application about to be evaluated in the environment
+func TraceApplication(env *Environment, pos Position, description string) {
+	if trace := env.Effects().Trace; trace != nil {
+		fmt.Fprintf(trace, "trace: %s %s\n", pos, description)
+	}
+}
+
+// Effects of the environment the builtin was applied in
+func (a Args) Effects() *Effects {
+	return a.env.Effects()
+}
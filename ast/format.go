@@ -0,0 +1,364 @@
+package ast
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+)
+
+// Formats a source file. Only whitespace changes, so comments and sugar like
+// `match`, `module` and `=>` are kept as written: lines are indented by how
+// deeply they are nested, blank lines are collapsed, tokens are separated by
+// at most a space and the arrows of consecutive pattern arms are lined up.
+// Line breaks end expressions, so every token stays on its line.
+func Format(file string, src []byte) ([]byte, error) {
+	if _, err := Parse(file, src); err != nil {
+		return nil, err
+	}
+
+	lines := formatLines(file, src)
+	layoutLines(lines)
+
+	res := []string{}
+
+	for i, l := range lines {
+		if i > 0 && l.number > lines[i-1].last+1 {
+			res = append(res, "")
+		}
+
+		res = append(res, pad(2*l.indent)+l.text())
+	}
+
+	return []byte(strings.Join(res, "\n") + "\n"), nil
+}
+
+// A line of tokens as it will be printed
+type formatLine struct {
+	number int
+	tokens []token
+	indent int
+
+	// Where the line ends, past the first when it ends in a multi-line string
+	last int
+
+	// Arms of a pattern are split at the arrow so arrows can be lined up
+	arrow   int
+	arm     *formatBracket
+	columns []string
+	align   []int
+}
+
+// Brackets, and bindings and matches continuing on the lines after them
+type formatBracket struct {
+	kind   int
+	indent int
+
+	// The body of the last arm is on the lines after its arrow
+	armBody bool
+
+	// Where the line of a binding or match starts in the source, it ends at
+	// the first line that isn't indented past it
+	char int
+}
+
+// Tokens grouped by line, along with their comments
+func formatLines(file string, src []byte) []*formatLine {
+	p := newParser(file, src)
+	p.comments = true
+
+	lines := []*formatLine{}
+
+	for t := p.Next(); t.kind != TOKEN_KIND_EOF; t = p.Next() {
+		if len(lines) == 0 || t.firstOfLine {
+			lines = append(lines, &formatLine{number: t.line, arrow: -1})
+		}
+
+		l := lines[len(lines)-1]
+		l.tokens = append(l.tokens, t)
+		l.last = t.line + bytes.Count(t.text, []byte("\n"))
+	}
+
+	return lines
+}
+
+func isOpening(kind int) bool {
+	return kind == TOKEN_KIND_BRACE_OPEN || kind == TOKEN_KIND_PAREN_OPEN || kind == TOKEN_KIND_BRACKET_OPEN
+}
+
+func isClosing(kind int) bool {
+	return kind == TOKEN_KIND_BRACE_CLOSE || kind == TOKEN_KIND_PAREN_CLOSE || kind == TOKEN_KIND_BRACKET_CLOSE
+}
+
+// Lines ending in these continue on the lines indented past them
+func isHanging(kind int) bool {
+	return kind == TOKEN_KIND_EQUAL || kind == TOKEN_KIND_MATCH
+}
+
+func isArrow(kind int) bool {
+	return kind == TOKEN_KIND_ARROW || kind == TOKEN_KIND_FAT_ARROW
+}
+
+// Lines are indented one level past the line opening the bracket they're in,
+// however many brackets that line opened, and arm bodies written on the lines
+// after their arrow one more
+func layoutLines(lines []*formatLine) {
+	open := []*formatBracket{}
+
+	for _, l := range lines {
+		for len(open) > 0 && open[len(open)-1].kind == TOKEN_KIND_EQUAL && l.tokens[0].char <= open[len(open)-1].char {
+			open = open[:len(open)-1]
+		}
+
+		var top *formatBracket
+
+		if len(open) > 0 {
+			top = open[len(open)-1]
+		}
+
+		switch {
+		case top == nil:
+			l.indent = 0
+
+		case isClosing(l.tokens[0].kind):
+			l.indent = top.indent
+
+		case top.kind == TOKEN_KIND_BRACE_OPEN && l.armArrow() >= 0:
+			l.indent = top.indent + 1
+			l.arrow = l.armArrow()
+			l.arm = top
+
+			// Nothing but a comment after the arrow, or a let binding
+			rest := l.code()[l.arrow+1:]
+			top.armBody = len(rest) == 0 || (len(rest) > 1 && rest[1].kind == TOKEN_KIND_EQUAL)
+
+		case top.kind == TOKEN_KIND_BRACE_OPEN && top.armBody:
+			l.indent = top.indent + 2
+
+		default:
+			l.indent = top.indent + 1
+		}
+
+		for _, t := range l.tokens {
+			switch {
+			case isOpening(t.kind):
+				open = append(open, &formatBracket{t.kind, l.indent, false, 0})
+
+			case isClosing(t.kind):
+				for len(open) > 0 && open[len(open)-1].kind == TOKEN_KIND_EQUAL {
+					open = open[:len(open)-1]
+				}
+
+				if len(open) > 0 {
+					open = open[:len(open)-1]
+				}
+			}
+		}
+
+		if code := l.code(); len(code) > 0 && isHanging(code[len(code)-1].kind) {
+			open = append(open, &formatBracket{TOKEN_KIND_EQUAL, l.indent, false, l.tokens[0].char})
+		}
+	}
+
+	alignArms(lines)
+}
+
+// The tokens of the line that aren't comments
+func (l *formatLine) code() []token {
+	if n := len(l.tokens); n > 0 && l.tokens[n-1].kind == TOKEN_KIND_COMMENT {
+		return l.tokens[:n-1]
+	}
+
+	return l.tokens
+}
+
+// Index of the arrow of an arm starting the line, -1 if it doesn't start one
+func (l *formatLine) armArrow() int {
+	depth := 0
+
+	for i, t := range l.code() {
+		switch {
+		case isOpening(t.kind):
+			depth++
+		case isClosing(t.kind):
+			depth--
+		case isArrow(t.kind) && depth == 0:
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Consecutive arms of a pattern line up their arrows, and their matches when
+// they have as many. Comments between them don't break the run, blank lines
+// and other lines do.
+func alignArms(lines []*formatLine) {
+	for start := 0; start < len(lines); {
+		if lines[start].arm == nil {
+			start++
+			continue
+		}
+
+		end := start + 1
+
+		for ; end < len(lines); end++ {
+			l := lines[end]
+
+			if l.number > lines[end-1].last+1 {
+				break
+			}
+
+			if len(l.code()) > 0 && l.arm != lines[start].arm {
+				break
+			}
+		}
+
+		alignColumns(lines[start:end])
+
+		start = end
+	}
+}
+
+func alignColumns(lines []*formatLine) {
+	columns := []int{}
+
+	for _, l := range lines {
+		if l.arm == nil {
+			continue
+		}
+
+		l.columns = []string{}
+
+		for _, match := range l.matches() {
+			l.columns = append(l.columns, joinTokens(match))
+		}
+
+		switch {
+		case len(l.columns) == 0:
+			// Default arms have no matches to line up
+		case len(columns) == 0:
+			columns = make([]int, len(l.columns))
+		case len(columns) != len(l.columns):
+			columns = []int{0}
+		}
+	}
+
+	// Matches line up when the arms have as many, otherwise only the arrows
+	for _, l := range lines {
+		if l.arm == nil || len(l.columns) == 0 {
+			continue
+		}
+
+		if len(columns) != len(l.columns) {
+			l.columns = []string{strings.Join(l.columns, " ")}
+		}
+
+		for i, column := range l.columns {
+			if w := utf8.RuneCountInString(column); w > columns[i] {
+				columns[i] = w
+			}
+		}
+	}
+
+	for _, l := range lines {
+		l.align = columns
+	}
+}
+
+// The matches of an arm before its arrow, each with its guard
+func (l *formatLine) matches() [][]token {
+	res := [][]token{}
+	depth := 0
+
+	for i, t := range l.tokens[:l.arrow] {
+		guarded := i > 0 && (l.tokens[i-1].kind == TOKEN_KIND_COLON || l.tokens[i-1].kind == TOKEN_KIND_DOUBLE_COLON)
+		guard := t.kind == TOKEN_KIND_COLON || t.kind == TOKEN_KIND_DOUBLE_COLON
+
+		if depth == 0 && !guarded && !guard {
+			res = append(res, []token{})
+		}
+
+		res[len(res)-1] = append(res[len(res)-1], t)
+
+		switch {
+		case isOpening(t.kind):
+			depth++
+		case isClosing(t.kind):
+			depth--
+		}
+	}
+
+	return res
+}
+
+func (l *formatLine) text() string {
+	if l.arm == nil || len(l.align) == 0 {
+		return joinTokens(l.tokens)
+	}
+
+	res := ""
+
+	for i, width := range l.align {
+		column := ""
+
+		if i < len(l.columns) {
+			column = l.columns[i]
+		}
+
+		res += column + pad(width-utf8.RuneCountInString(column)) + " "
+	}
+
+	return res + joinTokens(l.tokens[l.arrow:])
+}
+
+// Tokens separated by a space where the source had any, and always around
+// arrows and bindings and after commas
+func joinTokens(tokens []token) string {
+	res := ""
+
+	for i, t := range tokens {
+		if i > 0 {
+			prev := tokens[i-1]
+			spaced := t.char > tokenEnd(prev)
+
+			switch {
+			case t.kind == TOKEN_KIND_COMMA:
+				spaced = false
+			case prev.kind == TOKEN_KIND_COMMA, t.kind == TOKEN_KIND_COMMENT:
+				spaced = true
+			case isArrow(t.kind), isArrow(prev.kind):
+				spaced = true
+			case t.kind == TOKEN_KIND_EQUAL, prev.kind == TOKEN_KIND_EQUAL:
+				spaced = true
+			}
+
+			if spaced {
+				res += " "
+			}
+		}
+
+		res += tokenSource(t)
+	}
+
+	return res
+}
+
+// The token as written in the source, comments without trailing whitespace
+func tokenSource(t token) string {
+	if t.kind == TOKEN_KIND_COMMENT {
+		return string(t.value)
+	}
+
+	return string(t.text)
+}
+
+// Where the token ends on the last line it is on
+func tokenEnd(t token) int {
+	source := tokenSource(t)
+
+	if i := strings.LastIndex(source, "\n"); i >= 0 {
+		return len(source) - i
+	}
+
+	return t.char + len(source)
+}
@@ -0,0 +1,88 @@
+package ast
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the expected output of golden tests")
+
+// Each testdata/format/*.sl formats to the .golden file next to it
+func TestFormatGolden(t *testing.T) {
+	files, err := filepath.Glob("testdata/format/*.sl")
+
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no format test files: %v", err)
+	}
+
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := Format(file, src)
+
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+
+		golden := strings.TrimSuffix(file, ".sl") + ".golden"
+
+		if *update {
+			if err := ioutil.WriteFile(golden, res, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		expected, err := ioutil.ReadFile(golden)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(res) != string(expected) {
+			t.Errorf("%s formatted to\n%s\nexpected\n%s", file, res, expected)
+		}
+	}
+}
+
+// Formatting a formatted file changes nothing, and the bootstrap programs and
+// bundled packages are kept formatted
+func TestFormatIdempotent(t *testing.T) {
+	files := []string{}
+
+	for _, pattern := range []string{"testdata/format/*.golden", "../bootstrap/*.sl", "../slang/lib/*.sl"} {
+		matches, err := filepath.Glob(pattern)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		files = append(files, matches...)
+	}
+
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := Format(file, src)
+
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+
+		if string(res) != string(src) {
+			t.Errorf("%s is not formatted, it formats to\n%s", file, res)
+		}
+	}
+}
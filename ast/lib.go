@@ -1,5 +1,11 @@
 package ast
 
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
 var isLib = map[string]bool{}
 var StdLib Let
 
@@ -8,13 +14,19 @@ func init() {
 		isLib[b.name] = true
 	}
 
-	StdLib, _ = NewLet([]Identifier{}, []AST{}, nil)
+	for name := range logicalOps {
+		isLib[name] = true
+	}
 
-	for _, b := range libFns {
-		StdLib.Bind(Identifier{b.name}, b)
+	StdLib = DefaultBuiltins().Let()
+}
+
+func boolLabel(b bool) Label {
+	if b {
+		return True
 	}
 
-	StdLib.Body = Identifier{"NO BODY"}
+	return False
 }
 
 func IsBuiltin(v string) bool {
@@ -25,255 +37,253 @@ func IsBuiltin(v string) bool {
 	return false
 }
 
+// Numbers are promoted to decimals when either operand is a decimal
+func numericOperands(a AST, b AST) (int, int, float64, float64, bool, bool) {
+	switch A := a.(type) {
+	case Number:
+		switch B := b.(type) {
+		case Number:
+			return A.Value, B.Value, 0, 0, false, true
+		case Decimal:
+			return 0, 0, float64(A.Value), B.Value, true, true
+		}
+	case Decimal:
+		switch B := b.(type) {
+		case Number:
+			return 0, 0, A.Value, float64(B.Value), true, true
+		case Decimal:
+			return 0, 0, A.Value, B.Value, true, true
+		}
+	}
+
+	return 0, 0, 0, 0, false, false
+}
+
+func arithmetic(name string, verb string, ints func(int, int) (AST, error), decimals func(float64, float64) (AST, error)) Builtin {
+	message := fmt.Sprintf("Can't %s non-number type", verb)
+
+	return NewBuiltin(name, 2, func(args Args) (AST, error) {
+		x, y, dx, dy, isDecimal, ok := numericOperands(args.Value(0), args.Value(1))
+
+		if !ok {
+			return nil, NewRuntimeError(nil, message)
+		}
+
+		if isDecimal {
+			return decimals(dx, dy)
+		}
+
+		return ints(x, y)
+	})
+}
+
+// Orders numbers, strings and labels against values of the same kind
+func compare(a AST, b AST) (int, error) {
+	if x, y, dx, dy, isDecimal, ok := numericOperands(a, b); ok {
+		switch {
+		case isDecimal && dx < dy, !isDecimal && x < y:
+			return -1, nil
+		case isDecimal && dx > dy, !isDecimal && x > y:
+			return 1, nil
+		}
+
+		return 0, nil
+	}
+
+	switch A := a.(type) {
+	case String:
+		if B, ok := b.(String); ok {
+			return strings.Compare(A.Value, B.Value), nil
+		}
+	case Label:
+		if B, ok := b.(Label); ok {
+			return strings.Compare(A.Value, B.Value), nil
+		}
+	}
+
+	return 0, NewRuntimeError(nil, "Can't compare values of different or unordered types")
+}
+
+func comparison(name string, test func(int) bool) Builtin {
+	return NewBuiltin(name, 2, func(args Args) (AST, error) {
+		c, err := compare(args.Value(0), args.Value(1))
+
+		if err != nil {
+			return nil, err
+		}
+
+		return boolLabel(test(c)), nil
+	})
+}
+
+// Logical operators short circuit, so they are evaluated by applications
+// directly rather than being curried builtins which take evaluated operands
+var logicalOps = map[string]bool{
+	"&&": false,
+	"||": true,
+}
+
+func isBool(a AST) bool {
+	return a.Equals(True) || a.Equals(False)
+}
+
+func evalLogical(op string, lhs AST, rhs AST, env *Environment) (AST, error) {
+	shortCircuit := logicalOps[op]
+
+	a, err := lhs.Eval(env)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !isBool(a) {
+		return nil, NewRuntimeError(nil, fmt.Sprintf("Can't apply '%s' on non-boolean type", op))
+	}
+
+	if a.Equals(boolLabel(shortCircuit)) {
+		return a, nil
+	}
+
+	b, err := rhs.Eval(env)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !isBool(b) {
+		return nil, NewRuntimeError(nil, fmt.Sprintf("Can't apply '%s' on non-boolean type", op))
+	}
+
+	return b, nil
+}
+
 var libFns = []Builtin{
-	{
+	arithmetic(
 		"+",
-		func(a AST, env *Environment) (AST, error) {
-			switch A := a.(type) {
-			case Number:
-				return Builtin{
-					"+ curried",
-					func(b AST, env *Environment) (AST, error) {
-						switch B := b.(type) {
-						case Number:
-							return Number{A.Value + B.Value}, nil
-						}
-
-						return nil, NewRuntimeError(nil, "Can't add non-integer type")
-					},
-				}, nil
-			}
-
-			return nil, NewRuntimeError(nil, "Can't add non-integer type")
-		},
-	},
+		"add",
+		func(a, b int) (AST, error) { return Number{Value: a + b}, nil },
+		func(a, b float64) (AST, error) { return Decimal{Value: a + b}, nil },
+	),
 
-	{
+	arithmetic(
 		"*",
-		func(a AST, env *Environment) (AST, error) {
-			switch A := a.(type) {
-			case Number:
-				return Builtin{
-					"* curried",
-					func(b AST, env *Environment) (AST, error) {
-						switch B := b.(type) {
-						case Number:
-							return Number{A.Value * B.Value}, nil
-						}
-
-						return nil, NewRuntimeError(nil, "Can't multiply non-integer type")
-					},
-				}, nil
+		"multiply",
+		func(a, b int) (AST, error) { return Number{Value: a * b}, nil },
+		func(a, b float64) (AST, error) { return Decimal{Value: a * b}, nil },
+	),
+
+	arithmetic(
+		"/",
+		"divide",
+		func(a, b int) (AST, error) {
+			if b == 0 {
+				return nil, NewRuntimeError(nil, "Division by zero")
 			}
 
-			return nil, NewRuntimeError(nil, "Can't multiply non-integer type")
+			return Number{Value: a / b}, nil
 		},
-	},
-
-	{
-		"/",
-		func(a AST, env *Environment) (AST, error) {
-			switch A := a.(type) {
-			case Number:
-				return Builtin{
-					"/ curried",
-					func(b AST, env *Environment) (AST, error) {
-						switch B := b.(type) {
-						case Number:
-							return Number{A.Value / B.Value}, nil
-						}
-
-						return nil, NewRuntimeError(nil, "Can't divide non-integer type")
-					},
-				}, nil
+		func(a, b float64) (AST, error) {
+			if b == 0 {
+				return nil, NewRuntimeError(nil, "Division by zero")
 			}
 
-			return nil, NewRuntimeError(nil, "Can't divide non-integer type")
+			return Decimal{Value: a / b}, nil
 		},
-	},
+	),
 
-	{
+	arithmetic(
 		"%",
-		func(a AST, env *Environment) (AST, error) {
-			switch A := a.(type) {
-			case Number:
-				return Builtin{
-					"% curried",
-					func(b AST, env *Environment) (AST, error) {
-						switch B := b.(type) {
-						case Number:
-							return Number{A.Value % B.Value}, nil
-						}
-
-						return nil, NewRuntimeError(nil, "Can't modulo non-integer type")
-					},
-				}, nil
+		"modulo",
+		func(a, b int) (AST, error) {
+			if b == 0 {
+				return nil, NewRuntimeError(nil, "Division by zero")
 			}
 
-			return nil, NewRuntimeError(nil, "Can't modulo non-integer type")
+			return Number{Value: a % b}, nil
 		},
-	},
-
-	{
-		"-",
-		func(a AST, env *Environment) (AST, error) {
-			switch A := a.(type) {
-			case Number:
-				return Builtin{
-					"- curried",
-					func(b AST, env *Environment) (AST, error) {
-						switch B := b.(type) {
-						case Number:
-							return Number{A.Value - B.Value}, nil
-						}
-
-						return nil, NewRuntimeError(nil, "Can't subtract non-integer type")
-					},
-				}, nil
+		func(a, b float64) (AST, error) {
+			if b == 0 {
+				return nil, NewRuntimeError(nil, "Division by zero")
 			}
 
-			return nil, NewRuntimeError(nil, "Can't add non-integer type")
+			return Decimal{Value: math.Mod(a, b)}, nil
 		},
-	},
-
-	{
-		">",
-		func(a AST, env *Environment) (AST, error) {
-			switch A := a.(type) {
-			case Number:
-				return Builtin{
-					"> curried",
-					func(b AST, env *Environment) (AST, error) {
-						switch B := b.(type) {
-						case Number:
-							if A.Value > B.Value {
-								return Label{"true"}, nil
-							}
-
-							return Label{"false"}, nil
-						}
-
-						return nil, NewRuntimeError(nil, "Can't apply greater than on non-integer type")
-					},
-				}, nil
+	),
+
+	arithmetic(
+		"-",
+		"subtract",
+		func(a, b int) (AST, error) { return Number{Value: a - b}, nil },
+		func(a, b float64) (AST, error) { return Decimal{Value: a - b}, nil },
+	),
+
+	comparison(">", func(c int) bool { return c > 0 }),
+	comparison(">=", func(c int) bool { return c >= 0 }),
+	comparison("<", func(c int) bool { return c < 0 }),
+	comparison("<=", func(c int) bool { return c <= 0 }),
+
+	NewBuiltin("==", 2, func(args Args) (AST, error) {
+		return boolLabel(args.Value(0).Equals(args.Value(1))), nil
+	}),
+
+	NewBuiltin("!=", 2, func(args Args) (AST, error) {
+		return boolLabel(!args.Value(0).Equals(args.Value(1))), nil
+	}),
+
+	NewBuiltin("++", 2, func(args Args) (AST, error) {
+		if err := args.env.Budget().Allocate(Allocation(args.Value(0)) + Allocation(args.Value(1))); err != nil {
+			return nil, err
+		}
+
+		switch A := args.Value(0).(type) {
+		case List:
+			if B, ok := args.Value(1).(List); ok {
+				return List{Values: append(append([]AST{}, A.Values...), B.Values...)}, nil
 			}
 
-			return nil, NewRuntimeError(nil, "Can't apply greater than on non-integer type")
-		},
-	},
-
-	{
-		"||",
-		func(a AST, env *Environment) (AST, error) {
-			switch A := a.(type) {
-			case Label:
-				return Builtin{
-					"|| curried",
-					func(b AST, env *Environment) (AST, error) {
-						switch B := b.(type) {
-						case Label:
-							if A.Value == "true" || B.Value == "true" {
-								return Label{"true"}, nil
-							}
-
-							return Label{"false"}, nil
-						}
-
-						return nil, NewRuntimeError(nil, "Can't apply boolean or on non-boolean type")
-					},
-				}, nil
+		case String:
+			if B, ok := args.Value(1).(String); ok {
+				return String{Value: A.Value + B.Value}, nil
 			}
+		}
 
-			return nil, NewRuntimeError(nil, "Can't apply boolean or on non-boolean type")
-		},
-	},
-
-	{
-		"==",
-		func(a AST, env *Environment) (AST, error) {
-			return Builtin{
-				"== curried",
-				func(b AST, env *Environment) (AST, error) {
-					if a.Equals(b) {
-						return Label{"true"}, nil
-					}
-
-					return Label{"false"}, nil
-				},
-			}, nil
-		},
-	},
-
-	{
-		"!=",
-		func(a AST, env *Environment) (AST, error) {
-			return Builtin{
-				"!= curried",
-				func(b AST, env *Environment) (AST, error) {
-					if !a.Equals(b) {
-						return Label{"true"}, nil
-					}
-
-					return Label{"false"}, nil
-				},
-			}, nil
-		},
-	},
-
-	{
-		"++",
-		func(a AST, env *Environment) (AST, error) {
-			switch A := a.(type) {
-			case List:
-				return Builtin{
-					"++ curried-list",
-					func(b AST, env *Environment) (AST, error) {
-						switch B := b.(type) {
-						case List:
-							return List{append(append([]AST{}, A.Values...), B.Values...)}, nil
-						}
-
-						return nil, NewRuntimeError(nil, "Can't concatenate non-list type")
-					},
-				}, nil
-
-			case String:
-				return Builtin{
-					"++ curried-string",
-					func(b AST, env *Environment) (AST, error) {
-						switch B := b.(type) {
-						case String:
-							return String{A.Value + B.Value}, nil
-						}
-
-						return nil, NewRuntimeError(nil, "Can't concatenate non-list type")
-					},
-				}, nil
+		return nil, NewRuntimeError(nil, "Can't concatenate non-list type")
+	}),
 
-			}
+	NewBuiltin("print", 1, func(args Args) (AST, error) {
+		stdout := args.Effects().Stdout
 
-			return nil, NewRuntimeError(nil, "Can't concatenate non-list type")
-		},
-	},
+		if stdout == nil {
+			return nil, NewRuntimeError(nil, "Can't print without a stdout to write to")
+		}
 
-	{
-		"print",
-		func(a AST, env *Environment) (AST, error) {
-			Print(a)
+		if _, err := fmt.Fprintln(stdout, strings.Join(args.Value(0).String(), "\n")); err != nil {
+			return nil, NewRuntimeError(err, "Failed to print")
+		}
 
-			return a, nil
-		},
-	},
-
-	{
-		"len",
-		func(a AST, env *Environment) (AST, error) {
-			switch A := a.(type) {
-			case String:
-				return Number{len(A.Value)}, nil
-			}
+		return args.Value(0), nil
+	}),
 
-			return nil, NewRuntimeError(nil, "Can't find the length of non-string type")
-		},
-	},
+	// Strings as they are and anything else as it's printed, for interpolation
+	NewBuiltin("show", 1, func(args Args) (AST, error) {
+		if A, ok := args.Value(0).(String); ok {
+			return A, nil
+		}
+
+		res := String{Value: strings.Join(args.Value(0).String(), "\n")}
+
+		if err := args.env.Budget().Allocate(Allocation(res)); err != nil {
+			return nil, err
+		}
+
+		return res, nil
+	}),
+
+	NewBuiltin("len", 1, func(args Args) (AST, error) {
+		if A, ok := args.Value(0).(String); ok {
+			return Number{Value: len(A.Value)}, nil
+		}
+
+		return nil, NewRuntimeError(nil, "Can't find the length of non-string type")
+	}),
 }
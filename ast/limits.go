@@ -0,0 +1,139 @@
+package ast
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Limits on evaluating a program, zero leaves a limit off
+type Limits struct {
+	// Values applied to patterns and builtins
+	MaxSteps int
+
+	// Applications waiting on the result of another, tail calls don't count
+	MaxDepth int
+
+	// Elements of the lists and bytes of the strings created
+	MaxAllocation int
+}
+
+// Raised once an evaluation goes over one of its limits
+type LimitError struct {
+	Limit string
+	Max   int
+}
+
+func (e LimitError) Error() string {
+	return fmt.Sprintf("Exceeded the limit of %d %s", e.Max, e.Limit)
+}
+
+// Whether an error ends the evaluation, as reaching a limit or a done context
+// does, rather than only failing the expression it came from
+func IsLimitError(err error) bool {
+	var limit LimitError
+
+	return errors.As(err, &limit) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// How often steps check whether the context is done
+const contextCheckInterval = 1024
+
+// What one evaluation has used of its limits. Every environment of the
+// evaluation shares it, a nil budget is unlimited.
+type Budget struct {
+	ctx    context.Context
+	limits Limits
+
+	steps     int
+	depth     int
+	allocated int
+}
+
+func NewBudget(ctx context.Context, limits Limits) *Budget {
+	return &Budget{ctx: ctx, limits: limits}
+}
+
+// Counts an application, failing once the context is done
+func (b *Budget) Step() error {
+	if b == nil {
+		return nil
+	}
+
+	b.steps++
+
+	if b.limits.MaxSteps > 0 && b.steps > b.limits.MaxSteps {
+		return LimitError{"steps", b.limits.MaxSteps}
+	}
+
+	if b.steps%contextCheckInterval == 0 {
+		return b.ctx.Err()
+	}
+
+	return nil
+}
+
+// Enters an application that isn't a tail call, Leave must follow
+func (b *Budget) Enter() error {
+	if b == nil {
+		return nil
+	}
+
+	b.depth++
+
+	if b.limits.MaxDepth > 0 && b.depth > b.limits.MaxDepth {
+		return LimitError{"nested applications", b.limits.MaxDepth}
+	}
+
+	return nil
+}
+
+func (b *Budget) Leave() {
+	if b != nil {
+		b.depth--
+	}
+}
+
+// Counts elements of lists or bytes of strings being created
+func (b *Budget) Allocate(n int) error {
+	if b == nil {
+		return nil
+	}
+
+	b.allocated += n
+
+	if b.limits.MaxAllocation > 0 && b.allocated > b.limits.MaxAllocation {
+		return LimitError{"allocated list elements and string bytes", b.limits.MaxAllocation}
+	}
+
+	return nil
+}
+
+// Size of a value counted against the allocation limit, only lists and
+// strings are counted
+func Allocation(v AST) int {
+	switch V := v.(type) {
+	case List:
+		return len(V.Values)
+	case String:
+		return len(V.Value)
+	}
+
+	return 0
+}
+
+// A root environment evaluating within a budget
+func NewLimitedEnv(budget *Budget) *Environment {
+	env := NewEnv(nil)
+	env.budget = budget
+
+	return env
+}
+
+func (e *Environment) Budget() *Budget {
+	if e == nil {
+		return nil
+	}
+
+	return e.budget
+}
@@ -0,0 +1,469 @@
+package ast
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// Turns the syntax tree of a source file into the nodes that are evaluated.
+// Sugar is lowered here: operators and `match` become applications, modules
+// become patterns of their bindings and implicit arms gain their bodies.
+func LowerFile(n *Node) (*SourceFile, error) {
+	file := &SourceFile{}
+	nodes := n.nodes()
+
+	file.PackageName = nodes[0].Children[0].Token.Value
+
+	for _, imp := range nodes[1 : len(nodes)-1] {
+		file.Imports = append(file.Imports, lowerImport(imp))
+	}
+
+	ast, err := Lower(nodes[len(nodes)-1])
+
+	if err != nil {
+		return nil, err
+	}
+
+	file.Definition = ast
+
+	return file, nil
+}
+
+func lowerImport(n *Node) SourceFileImport {
+	path := n.nodes()[0].Children[0].Token
+	imp := SourceFileImport{path.Value, "", nil, path.Pos}
+
+	for _, child := range n.nodes()[1:] {
+		switch child.Kind {
+		case NODE_KIND_IDENTIFIER:
+			imp.Name = child.Children[0].Token.Value
+
+		case NODE_KIND_PARENS:
+			imp.Names = []string{}
+
+			for _, name := range child.nodes() {
+				imp.Names = append(imp.Names, name.Children[0].Token.Value)
+			}
+		}
+	}
+
+	return imp
+}
+
+func lowerIdentifier(n *Node) Identifier {
+	t := n.Children[0].Token
+	id, _ := NewIdentifier(t.Value)
+	id.Pos = t.Pos
+
+	return id
+}
+
+// Lowers an expression of the syntax tree
+func Lower(n *Node) (AST, error) {
+	switch n.Kind {
+	case NODE_KIND_IDENTIFIER:
+		return lowerIdentifier(n), nil
+
+	case NODE_KIND_LABEL:
+		t := n.Children[0].Token
+		label, _ := NewLabel(t.Value)
+		label.Pos = t.Pos
+
+		return label, nil
+
+	case NODE_KIND_STRING:
+		if len(n.Children) > 1 {
+			return lowerInterpolation(n)
+		}
+
+		return lowerString(n.Children[0].Token), nil
+
+	case NODE_KIND_NUMBER:
+		t := n.Children[0].Token
+		value := 0
+
+		for i := 0; i < len(t.Value); i++ {
+			value = value*10 + int(t.Value[i]-'0')
+		}
+
+		res, _ := NewNumber(value)
+		res.Pos = t.Pos
+
+		return res, nil
+
+	case NODE_KIND_DECIMAL:
+		t := n.Children[0].Token
+		value, err := strconv.ParseFloat(t.Value, 64)
+
+		if err != nil {
+			return nil, NewParseErrorAt(t.Pos, err, "Cannot parse decimal")
+		}
+
+		res, _ := NewDecimal(value)
+		res.Pos = t.Pos
+
+		return res, nil
+
+	case NODE_KIND_PARENS:
+		return Lower(n.nodes()[0])
+
+	case NODE_KIND_APPLICATION:
+		body, err := lowerAll(n.nodes())
+
+		if err != nil {
+			return nil, err
+		}
+
+		return Application{Body: body, Pos: n.Pos()}, nil
+
+	case NODE_KIND_OPERATION:
+		op := n.Children[1].Token
+		body, err := lowerAll(n.nodes())
+
+		if err != nil {
+			return nil, err
+		}
+
+		return Application{Body: append([]AST{Identifier{Value: op.Value, Pos: op.Pos}}, body...), Pos: op.Pos}, nil
+
+	case NODE_KIND_LET:
+		return lowerLet(n)
+
+	case NODE_KIND_MATCH:
+		nodes := n.nodes()
+		toMatch, err := Lower(nodes[0])
+
+		if err != nil {
+			return nil, err
+		}
+
+		with, err := Lower(nodes[1])
+
+		if err != nil {
+			return nil, err
+		}
+
+		app, _ := NewApplication([]AST{with, toMatch})
+		app.Pos = n.token(TOKEN_KIND_MATCH).Pos
+
+		return app, nil
+
+	case NODE_KIND_PATTERN:
+		return lowerPattern(n)
+
+	case NODE_KIND_WHERE:
+		return lowerWhere(n)
+
+	case NODE_KIND_MODULE:
+		return lowerModule(n)
+
+	case NODE_KIND_LIST:
+		list := List{Pos: n.Pos()}
+
+		for _, child := range n.nodes() {
+			v, err := Lower(child)
+
+			if err != nil {
+				return nil, err
+			}
+
+			list.Values = append(list.Values, v)
+		}
+
+		return list, nil
+
+	case NODE_KIND_LIST_CONSTRUCTOR:
+		nodes := n.nodes()
+		head, err := Lower(nodes[0])
+
+		if err != nil {
+			return nil, err
+		}
+
+		tail, err := Lower(nodes[1])
+
+		if err != nil {
+			return nil, err
+		}
+
+		return ListConstructor{Head: head, Tail: tail, Pos: n.Pos()}, nil
+	}
+
+	return nil, NewParseErrorAt(n.Pos(), nil, "Unexpected "+nodeKindNames[n.Kind]+" in expression")
+}
+
+func lowerAll(nodes []*Node) ([]AST, error) {
+	res := []AST{}
+
+	for _, n := range nodes {
+		v, err := Lower(n)
+
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, v)
+	}
+
+	return res, nil
+}
+
+// Escapes were decoded when scanning
+func lowerString(t *Token) String {
+	res, _ := NewString(t.Value)
+	res.Pos = t.Pos
+
+	return res
+}
+
+// Interpolated strings concatenate their text with the values between it,
+// converted to strings by show. Escapes are decoded here, after triple
+// quoted strings lose their indentation as a whole.
+func lowerInterpolation(n *Node) (AST, error) {
+	pos := n.Pos()
+	texts := []string{}
+
+	for _, child := range n.Children {
+		if child.Token != nil {
+			texts = append(texts, child.Token.Value)
+		}
+	}
+
+	// Interpolations hold their place while dedenting
+	if strings.HasPrefix(n.Children[0].Token.Text, `"""`) {
+		lines := bytes.Split([]byte(strings.Join(texts, "\x00")), []byte("\n"))
+		texts = strings.Split(string(dedent(lines)), "\x00")
+	}
+
+	var res AST
+
+	add := func(v AST) {
+		if res == nil {
+			res = v
+		} else {
+			res = Application{[]AST{Identifier{"++", pos}, res, v}, pos}
+		}
+	}
+
+	for i, expr := range n.nodes() {
+		if text, _ := unescape([]byte(texts[i])); len(text) > 0 {
+			add(String{string(text), pos})
+		}
+
+		v, err := Lower(expr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		add(Application{[]AST{Identifier{"show", expr.Pos()}, v}, expr.Pos()})
+	}
+
+	if text, _ := unescape([]byte(texts[len(texts)-1])); len(text) > 0 {
+		add(String{string(text), pos})
+	}
+
+	return res, nil
+}
+
+// Lets whose body is a let are merged into one binding them all
+func lowerLet(n *Node) (AST, error) {
+	nodes := n.nodes()
+	identifier := lowerIdentifier(nodes[0])
+	value, err := Lower(nodes[1])
+
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := Lower(nodes[2])
+
+	if err != nil {
+		return nil, err
+	}
+
+	var let Let
+
+	switch b := body.(type) {
+	case Let:
+		let, _ = NewLet(
+			append([]Identifier{identifier}, b.BoundIds...),
+			append([]AST{value}, b.BoundValues...),
+			b.Body,
+		)
+	default:
+		let, _ = NewLet(
+			[]Identifier{identifier},
+			[]AST{value},
+			body,
+		)
+	}
+
+	let.Pos = identifier.Pos
+
+	return let, nil
+}
+
+// Default arms match anything in each position, and an implicit arm is true
+// when it matches and false otherwise
+func lowerPattern(n *Node) (AST, error) {
+	pos := n.Pos()
+	matchBodies := [][]AST{}
+	bodies := []AST{}
+
+	for _, arm := range n.nodes() {
+		nodes := arm.nodes()
+		matches := []AST{}
+
+		switch arm.Kind {
+		case NODE_KIND_DEFAULT_ARM:
+			for range matchBodies[0] {
+				id, _ := NewIdentifier("_")
+				id.Pos = pos
+				matches = append(matches, id)
+			}
+
+		case NODE_KIND_IMPLICIT_ARM:
+			falseMatches := []AST{}
+
+			for _, match := range nodes {
+				m, err := Lower(match)
+
+				if err != nil {
+					return nil, err
+				}
+
+				id, _ := NewIdentifier("_")
+				matches = append(matches, m)
+				falseMatches = append(falseMatches, id)
+			}
+
+			bodies = append(bodies, True)
+			bodies = append(bodies, False)
+			matchBodies = append(matchBodies, matches)
+			matchBodies = append(matchBodies, falseMatches)
+
+			continue
+
+		default:
+			for _, match := range nodes[:len(nodes)-1] {
+				m, err := Lower(match)
+
+				if err != nil {
+					return nil, err
+				}
+
+				matches = append(matches, m)
+			}
+		}
+
+		body, err := Lower(nodes[len(nodes)-1])
+
+		if err != nil {
+			return nil, err
+		}
+
+		matchBodies = append(matchBodies, matches)
+		bodies = append(bodies, body)
+	}
+
+	pattern, _ := NewPattern(matchBodies, bodies)
+	pattern.Pos = pos
+
+	return pattern, nil
+}
+
+// Guards after a double colon must run in constant time
+func lowerWhere(n *Node) (AST, error) {
+	nodes := n.nodes()
+	constantTime := n.token(TOKEN_KIND_DOUBLE_COLON) != nil
+	pos := n.Children[1].Token.Pos
+
+	match, err := Lower(nodes[0])
+
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := Lower(nodes[1])
+
+	if err != nil {
+		return nil, err
+	}
+
+	if constantTime {
+		if err := checkConstantTime(body, nil); err != nil {
+			guardErr := err.(RuntimeError)
+
+			return nil, NewParseErrorAt(guardErr.pos, nil, guardErr.message)
+		}
+	}
+
+	where, _ := NewWhere(match, body, constantTime)
+	where.Pos = pos
+
+	return where, nil
+}
+
+// A module is a let of its bindings with a pattern selecting them by label as
+// its body. Parameters wrap it in a pattern taking them first.
+func lowerModule(n *Node) (AST, error) {
+	pos := n.Pos()
+	params := []AST{}
+	boundIds := []Identifier{}
+	boundValues := []AST{}
+
+	for _, child := range n.nodes() {
+		switch child.Kind {
+		case NODE_KIND_IDENTIFIER:
+			params = append(params, lowerIdentifier(child))
+
+		case NODE_KIND_BINDING:
+			nodes := child.nodes()
+			value, err := Lower(nodes[1])
+
+			if err != nil {
+				return nil, err
+			}
+
+			boundIds = append(boundIds, lowerIdentifier(nodes[0]))
+			boundValues = append(boundValues, value)
+		}
+	}
+
+	matchGroups := [][]AST{}
+	bodies := []AST{}
+
+	for _, id := range boundIds {
+		matchGroups = append(matchGroups, []AST{Label{id.Value, id.Pos}})
+		bodies = append(bodies, id)
+	}
+
+	body, _ := NewPattern(matchGroups, bodies)
+	body.Pos = pos
+	let, _ := NewLet(boundIds, boundValues, body)
+	let.Pos = pos
+
+	// Generate params pattern and let
+	if len(params) > 0 {
+		uniqueMatch := []AST{}
+		uniqueIds := []Identifier{}
+		moduleId := NextUniqueId()
+
+		for _, param := range params {
+			uid := NextUniqueId()
+			uniqueMatch = append(uniqueMatch, uid)
+			uniqueIds = append(uniqueIds, param.(Identifier))
+		}
+
+		paramLet, _ := NewLet(uniqueIds, uniqueMatch, Application{append([]AST{let, moduleId}, uniqueMatch...), pos})
+		paramLet.Pos = pos
+		paramPattern, _ := NewPattern([][]AST{append([]AST{moduleId}, uniqueMatch...)}, []AST{paramLet})
+		paramPattern.Pos = pos
+
+		return paramPattern, nil
+	}
+
+	return let, nil
+}
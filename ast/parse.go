@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 const (
@@ -53,9 +56,18 @@ const (
 	TOKEN_KIND_LABEL
 	TOKEN_KIND_STRING
 	TOKEN_KIND_NUMBER
+	TOKEN_KIND_DECIMAL
 
 	TOKEN_KIND_ERROR
 	TOKEN_KIND_EOF
+
+	// Only scanned when the parser keeps comments
+	TOKEN_KIND_COMMENT
+
+	// Text of a string before, between and after its interpolations
+	TOKEN_KIND_STRING_START
+	TOKEN_KIND_STRING_MIDDLE
+	TOKEN_KIND_STRING_END
 )
 
 var opPrecedence [][]int = [][]int{
@@ -94,6 +106,10 @@ type token struct {
 
 	firstOfLine  bool // this token is first on its line
 	skippedSpace bool // this token did not skip spaces before getting parsed
+
+	// As written, and the whitespace and comments skipped before it
+	text    []byte
+	leading []byte
 }
 
 func pad(len int) string {
@@ -109,66 +125,142 @@ func pad(len int) string {
 type ParseError struct {
 	wrapped error
 	message string
+
+	// What went wrong, from where up to the end of the offending token
+	Reason string
+	Pos    Position
+	End    Position
 }
 
-/*
+// Points at the next token, past whatever was parsed
+func NewParseError(p *parser, wrapped error, err string) *ParseError {
+	t := p.Peek()
+	pos := p.pos(t)
+	end := pos
+	text := t.text
 
-Formatted like:
+	// Up to the end of the line for tokens spanning lines
+	if i := bytes.IndexByte(text, '\n'); i >= 0 {
+		text = text[:i]
+	}
 
-   | package sketch
-   |
- 3 | test = {
-          ^
-   ERROR: msg
+	end.Char += len(bytes.TrimRightFunc(text, unicode.IsSpace))
 
-*/
-func NewParseError(p *parser, wrapped error, err string) *ParseError {
-	lineNum := fmt.Sprintf("%d", p.line)
-	message := "\n"
-	padStr := fmt.Sprintf(pad(len(lineNum)+2))
+	return newParseError(pos, end, wrapped, err)
+}
 
-	for i := numberOfSavedLines-1; i >= 0; i-- {
-		if i == 0 {
-			message += fmt.Sprintf(" %s ", lineNum)
-		} else {
-			message += padStr
+// Points at a node that was already parsed rather than the current token
+func NewParseErrorAt(pos Position, wrapped error, err string) *ParseError {
+	return newParseError(pos, pos, wrapped, err)
+}
+
+// Points at the tokens of a syntax tree node on its first line
+func nodeParseError(n *Node, wrapped error, err string) *ParseError {
+	tokens := n.Tokens()
+	pos := n.Pos()
+	end := pos
+
+	for _, t := range tokens {
+		if t.Pos.Line == pos.Line {
+			end = t.Pos
+			end.Char += len(strings.SplitN(t.Text, "\n", 2)[0])
 		}
+	}
+
+	return newParseError(pos, end, wrapped, err)
+}
 
-		message += fmt.Sprintf("| %s\n", string(p.savedLines[i]))
+func newParseError(pos Position, end Position, wrapped error, err string) *ParseError {
+	// Errors from outside the parser, like reading a file, explain this one
+	if _, ok := wrapped.(*ParseError); wrapped != nil && !ok {
+		err += ": " + wrapped.Error()
 	}
 
-	message += padStr + pad(p.char) + "^"
-	message += "\n"+padStr
-	message += fmt.Sprintf("ERROR: %s\n", err)
+	width := 1
+
+	if end.Line == pos.Line && end.Char > pos.Char {
+		width = end.Char - pos.Char
+	}
 
 	return &ParseError{
 		wrapped,
-		message,
+		sourceSpanDisplay(pos.Line, pos.Char, width, sourceLines(pos), err),
+		err,
+		pos,
+		end,
 	}
 }
 
+// Only the innermost error is shown, it is where parsing went wrong while
+// the errors wrapping it only say what was being parsed
 func (e *ParseError) Error() string {
-	if e.wrapped == nil {
-		return e.message
-	}
+	return e.innermost().message
+}
+
+func (e *ParseError) innermost() *ParseError {
+	for {
+		wrapped, ok := e.wrapped.(*ParseError)
+
+		if !ok {
+			return e
+		}
 
-	return e.wrapped.Error()
+		e = wrapped
+	}
 }
 
 func (e *ParseError) Unwrap() error {
 	return e.wrapped
 }
 
+// Every error found in a file, parsing recovers from errors in arms,
+// module bindings and lets to find the ones after them
+type ParseErrors []*ParseError
+
+func (e ParseErrors) Error() string {
+	res := ""
+
+	for _, err := range e {
+		res += err.Error()
+	}
+
+	return res
+}
+
 const numberOfSavedLines = 4
 
 type parser struct {
-	src []byte
+	file string
+	src  []byte
 
 	line int
 	char int
 
 	savedLines [numberOfSavedLines][]byte
 	currLine []byte
+
+	// Comments are returned as tokens rather than skipped, for the formatter
+	comments bool
+
+	// The whole source, where each line starts in it and the end of the last
+	// token scanned
+	whole      []byte
+	lineStarts []int
+	offset     int
+
+	// Brackets opened and not yet closed, and the errors recovered from
+	depth       int
+	diagnostics []*ParseError
+
+	// Strings whose interpolations are being scanned, innermost last. It is
+	// copied rather than changed, so saved parsers keep theirs.
+	interpolations []interpolation
+}
+
+// An interpolation in a string, counting the braces opened within it
+type interpolation struct {
+	braces int
+	triple bool
 }
 
 func (p *parser) nextLine()  {
@@ -223,14 +315,43 @@ func (p *parser) SkipWS() {
 	p.currLine = p.currLine[i:]
 }
 
+// Scans the next token, along with the source it was scanned from
 func (p *parser) Next() token {
+	t := p.scan()
+	start := len(p.whole)
+	end := start
+
+	if t.kind != TOKEN_KIND_EOF {
+		start = p.lineStarts[t.line-1] + t.char - 1
+		end = start
+	}
+
+	if t.kind != TOKEN_KIND_EOF {
+		end = p.lineStarts[p.line-1] + p.char - 1
+	}
+
+	t.leading = p.whole[p.offset:start]
+	t.text = p.whole[start:end]
+	p.offset = end
+
+	switch {
+	case isOpening(t.kind):
+		p.depth++
+	case isClosing(t.kind) && p.depth > 0:
+		p.depth--
+	}
+
+	return t
+}
+
+func (p *parser) scan() token {
 	oldLen := len(p.src)
 	oldLine := p.line
 
 	for !p.isEOF() {
 		p.SkipWS()
 
-		if !p.currLineIsEmpty() && p.currLine[0] != '#' {
+		if !p.currLineIsEmpty() && (p.currLine[0] != '#' || p.comments) {
 			break
 		}
 
@@ -240,6 +361,25 @@ func (p *parser) Next() token {
 	firstOfLine := oldLine != p.line
 	skippedSpace := oldLen != len(p.src)
 
+	// Comments run to the end of the line
+	if !p.isEOF() && p.currLine[0] == '#' {
+		token := token{
+			TOKEN_KIND_COMMENT,
+			bytes.TrimRightFunc(p.currLine, unicode.IsSpace),
+			p.line,
+			p.char,
+			firstOfLine,
+			skippedSpace,
+			nil,
+			nil,
+		}
+
+		p.char += len(p.currLine)
+		p.currLine = p.currLine[len(p.currLine):]
+
+		return token
+	}
+
 	if p.isEOF() {
 		return token{
 			TOKEN_KIND_EOF,
@@ -248,7 +388,41 @@ func (p *parser) Next() token {
 			p.char,
 			firstOfLine,
 			skippedSpace,
+			nil,
+			nil,
+		}
+	}
+
+	// Braces end interpolations in strings, unless they close braces opened
+	// within them
+	if n := len(p.interpolations); n > 0 && (p.currLine[0] == '{' || p.currLine[0] == '}') {
+		top := p.interpolations[n-1]
+
+		switch {
+		case p.currLine[0] == '}' && top.braces == 0:
+			res := token{
+				TOKEN_KIND_STRING_END,
+				nil,
+				p.line,
+				p.char,
+				firstOfLine,
+				skippedSpace,
+				nil,
+				nil,
+			}
+
+			p.char++
+			p.currLine = p.currLine[1:]
+
+			return p.scanStringText(res, false, top.triple, false)
+
+		case p.currLine[0] == '{':
+			top.braces++
+		default:
+			top.braces--
 		}
+
+		p.interpolations = append(append([]interpolation{}, p.interpolations[:n-1]...), top)
 	}
 
 	// Parse reserved
@@ -292,6 +466,8 @@ func (p *parser) Next() token {
 				p.char,
 				firstOfLine,
 				skippedSpace,
+				nil,
+				nil,
 			}
 
 			p.char += len(s)
@@ -301,6 +477,11 @@ func (p *parser) Next() token {
 		}
 	}
 
+	// Parse string, raw strings are prefixed with r
+	if p.currLine[0] == '"' || bytes.HasPrefix(p.currLine, []byte(`r"`)) {
+		return p.scanString(firstOfLine, skippedSpace)
+	}
+
 	// Parse identifier
 	if unicode.IsLetter(rune(p.currLine[0])) || p.currLine[0] == '_' {
 		i := 0
@@ -315,6 +496,8 @@ func (p *parser) Next() token {
 			p.char,
 			firstOfLine,
 			skippedSpace,
+			nil,
+			nil,
 		}
 
 		p.char += i
@@ -337,6 +520,8 @@ func (p *parser) Next() token {
 			p.char,
 			firstOfLine,
 			skippedSpace,
+			nil,
+			nil,
 		}
 
 		p.char += i
@@ -345,46 +530,30 @@ func (p *parser) Next() token {
 		return token
 	}
 
-	// Parse string
-	if p.currLine[0] == '"' {
-		i := 1
+	// Parse number, a point directly followed by a digit makes it a decimal
+	if unicode.IsNumber(rune(p.currLine[0])) {
+		i := 0
+		kind := TOKEN_KIND_NUMBER
 
-		for ; i < len(p.currLine) && p.currLine[i] != '"'; i++ {
+		for ; i < len(p.currLine) && unicode.IsNumber(rune(p.currLine[i])); i++ {
 		}
 
-		if i < len(p.currLine) && p.currLine[i] == '"' {
-			i++
+		if i+1 < len(p.currLine) && p.currLine[i] == '.' && unicode.IsNumber(rune(p.currLine[i+1])) {
+			kind = TOKEN_KIND_DECIMAL
 
-			token := token{
-				TOKEN_KIND_STRING,
-				p.currLine[1 : i-1],
-				p.line,
-				p.char,
-				firstOfLine,
-				skippedSpace,
+			for i++; i < len(p.currLine) && unicode.IsNumber(rune(p.currLine[i])); i++ {
 			}
-
-			p.char += i
-			p.currLine = p.currLine[i:]
-
-			return token
-		}
-	}
-
-	// Parse number
-	if unicode.IsNumber(rune(p.currLine[0])) {
-		i := 0
-
-		for ; i < len(p.currLine) && unicode.IsNumber(rune(p.currLine[i])); i++ {
 		}
 
 		token := token{
-			TOKEN_KIND_NUMBER,
+			kind,
 			p.currLine[:i],
 			p.line,
 			p.char,
 			firstOfLine,
 			skippedSpace,
+			nil,
+			nil,
 		}
 
 		p.char += i
@@ -393,14 +562,266 @@ func (p *parser) Next() token {
 		return token
 	}
 
-	return token{
+	// The rest of the line is skipped so parsing can recover after it
+	token := token{
 		TOKEN_KIND_ERROR,
 		[]byte("Unexpected end of parsing"),
 		p.line,
 		p.char,
 		firstOfLine,
 		skippedSpace,
+		nil,
+		nil,
+	}
+
+	p.char += len(p.currLine)
+	p.currLine = p.currLine[len(p.currLine):]
+
+	return token
+}
+
+// Strings are quoted on a line, or triple quoted across lines. Escapes are
+// decoded unless the string is raw.
+func (p *parser) scanString(firstOfLine bool, skippedSpace bool) token {
+	res := token{
+		TOKEN_KIND_STRING,
+		nil,
+		p.line,
+		p.char,
+		firstOfLine,
+		skippedSpace,
+		nil,
+		nil,
+	}
+
+	raw := p.currLine[0] == 'r'
+
+	if raw {
+		p.char++
+		p.currLine = p.currLine[1:]
+	}
+
+	triple := bytes.HasPrefix(p.currLine, []byte(`"""`))
+	quotes := 1
+
+	if triple {
+		quotes = 3
+	}
+
+	p.char += quotes
+	p.currLine = p.currLine[quotes:]
+
+	return p.scanStringText(res, raw, triple, true)
+}
+
+// Scans the text of a string up to its closing quotes, or the brace opening
+// an interpolation. The text before, between and after interpolations is
+// kept as written, escapes and indentation are left for lowering.
+func (p *parser) scanStringText(res token, raw bool, triple bool, opening bool) token {
+	lines := [][]byte{}
+	interpolated := false
+
+	for {
+		end := -1
+
+		for i := 0; i < len(p.currLine) && end < 0; i++ {
+			switch {
+			case p.currLine[i] == '\\' && !raw:
+				i++
+
+				// The braces of unicode escapes don't interpolate
+				if bytes.HasPrefix(p.currLine[i:], []byte("u{")) {
+					if end := bytes.IndexAny(p.currLine[i+2:], `{}"\`); end >= 0 && p.currLine[i+2+end] == '}' {
+						i += 2 + end
+					}
+				}
+
+			case p.currLine[i] == '{' && !raw:
+				end = i
+				interpolated = true
+
+			case triple && bytes.HasPrefix(p.currLine[i:], []byte(`"""`)), !triple && p.currLine[i] == '"':
+				end = i
+			}
+		}
+
+		if end >= 0 {
+			lines = append(lines, p.currLine[:end])
+			width := 1
+
+			if triple && !interpolated {
+				width = 3
+			}
+
+			p.char += end + width
+			p.currLine = p.currLine[end+width:]
+
+			break
+		}
+
+		lines = append(lines, p.currLine)
+
+		if !triple || len(p.src) == 0 {
+			res.kind = TOKEN_KIND_ERROR
+			res.value = []byte("String must be closed on the line it begins, use triple quotes for multi-line strings")
+
+			if triple {
+				res.value = []byte(`Multi-line string must be closed by triple quotes """`)
+			}
+
+			// Most likely the quotes of the string the interpolation is in
+			if opening && len(p.interpolations) > 0 {
+				res.value = []byte("Interpolation in string must be closed by '}'")
+			}
+
+			if !opening {
+				p.interpolations = p.interpolations[:len(p.interpolations)-1]
+			}
+
+			p.char += len(p.currLine)
+			p.currLine = p.currLine[len(p.currLine):]
+
+			return res
+		}
+
+		p.nextLine()
+	}
+
+	switch {
+	case opening && !interpolated:
+		res.value = dedent(lines)
+
+		if raw {
+			return res
+		}
+
+		return unescapeToken(res)
+
+	case opening:
+		res.kind = TOKEN_KIND_STRING_START
+		p.interpolations = append(append([]interpolation{}, p.interpolations...), interpolation{0, triple})
+
+	case interpolated:
+		res.kind = TOKEN_KIND_STRING_MIDDLE
+
+	default:
+		res.kind = TOKEN_KIND_STRING_END
+		p.interpolations = p.interpolations[:len(p.interpolations)-1]
+	}
+
+	res.value = bytes.Join(lines, []byte("\n"))
+
+	// Escapes are only checked here
+	if t := unescapeToken(res); t.kind == TOKEN_KIND_ERROR {
+		return t
 	}
+
+	return res
+}
+
+// Lines of a multi-line string lose the indentation of its closing quotes,
+// when they are on a line of their own, and the line breaks after the
+// opening quotes and before the closing ones aren't part of it
+func dedent(lines [][]byte) []byte {
+	if len(lines) == 1 {
+		return lines[0]
+	}
+
+	if len(bytes.TrimSpace(lines[0])) == 0 {
+		lines = lines[1:]
+	}
+
+	indent := []byte{}
+
+	if last := lines[len(lines)-1]; len(bytes.TrimSpace(last)) == 0 {
+		indent = last
+		lines = lines[:len(lines)-1]
+	}
+
+	res := [][]byte{}
+
+	for _, line := range lines {
+		switch {
+		case bytes.HasPrefix(line, indent):
+			line = line[len(indent):]
+		case len(bytes.TrimSpace(line)) == 0:
+			line = []byte{}
+		}
+
+		res = append(res, line)
+	}
+
+	return bytes.Join(res, []byte("\n"))
+}
+
+// Decodes the escapes of a string token, making it an error when one is
+// invalid
+func unescapeToken(t token) token {
+	value, err := unescape(t.value)
+
+	if err != "" {
+		t.kind = TOKEN_KIND_ERROR
+		value = []byte(err)
+	}
+
+	t.value = value
+
+	return t
+}
+
+// Escapes are \n, \t, \r, \", \\, \{, \} and \u{...} with the hex code of a
+// character
+func unescape(s []byte) ([]byte, string) {
+	res := []byte{}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			res = append(res, s[i])
+			continue
+		}
+
+		i++
+
+		if i == len(s) {
+			return nil, "String cannot end with a lone '\\'"
+		}
+
+		switch s[i] {
+		case 'n':
+			res = append(res, '\n')
+		case 't':
+			res = append(res, '\t')
+		case 'r':
+			res = append(res, '\r')
+		case '"', '\\', '{', '}':
+			res = append(res, s[i])
+
+		case 'u':
+			end := bytes.IndexByte(s[i:], '}')
+
+			if i+1 == len(s) || s[i+1] != '{' || end < 0 {
+				return nil, "Unicode escapes must be written as \\u{...}"
+			}
+
+			code, err := strconv.ParseUint(string(s[i+2:i+end]), 16, 32)
+
+			if err != nil || !utf8.ValidRune(rune(code)) {
+				return nil, fmt.Sprintf("Invalid unicode escape '\\u{%s}'", s[i+2:i+end])
+			}
+
+			res = utf8.AppendRune(res, rune(code))
+			i += end
+
+		default:
+			return nil, fmt.Sprintf("Unknown escape sequence '\\%c' in string", s[i])
+		}
+	}
+
+	return res, ""
+}
+
+func (p *parser) pos(t token) Position {
+	return Position{p.file, t.line, t.char}
 }
 
 func (p *parser) Peek() token {
@@ -414,6 +835,9 @@ func (p *parser) Peek() token {
 	}
 
 	currLine := p.currLine
+	offset := p.offset
+	depth := p.depth
+	interpolations := p.interpolations
 
 	token := p.Next()
 
@@ -422,34 +846,141 @@ func (p *parser) Peek() token {
 	p.char = char
 	p.savedLines = savedLines
 	p.currLine = currLine
+	p.offset = offset
+	p.depth = depth
+	p.interpolations = interpolations
 
 	return token
 }
 
-func (p *parser) ConsumeIfNext(kind int) bool {
+// A token as a leaf of the syntax tree
+func (p *parser) leaf(t token) *Node {
+	return &Node{NODE_KIND_TOKEN, &Token{tokenKindNames[t.kind], string(t.value), string(t.text), string(t.leading), p.pos(t), t.kind}, nil}
+}
+
+// Adds the next token to the node if it is of the kind
+func (p *parser) consume(n *Node, kind int) bool {
 	if p.Peek().kind == kind {
-		p.Next()
+		n.add(p.leaf(p.Next()))
 		return true
 	}
 
 	return false
 }
 
-func (p *parser) Identifier() (AST, error) {
+// Keeps an error to return once the file is parsed. Errors are passed up
+// wrapped after recovering from them fails, those already kept aren't again.
+func (p *parser) report(err error) {
+	parseErr, ok := err.(*ParseError)
+
+	if !ok {
+		parseErr = NewParseError(p, err, "Cannot parse")
+	}
+
+	parseErr = parseErr.innermost()
+
+	for _, reported := range p.diagnostics {
+		if reported == parseErr || (reported.Pos == parseErr.Pos && reported.Reason == parseErr.Reason) {
+			return
+		}
+	}
+
+	p.diagnostics = append(p.diagnostics, parseErr)
+}
+
+// Every error kept, along with err
+func (p *parser) failed(err error) error {
+	p.report(err)
+
+	return ParseErrors(p.diagnostics)
+}
+
+// Skips the rest of a statement after an error, from start up to a line
+// starting at or before char or a bracket closing one opened before depth.
+// Brackets the statement left open are abandoned.
+func (p *parser) skip(start int, char int, depth int) *Node {
+	skipped := newNode(NODE_KIND_ERROR)
+
+	for {
+		t := p.Peek()
+
+		switch {
+		case t.kind == TOKEN_KIND_EOF:
+			return skipped
+
+		case p.offset > start && t.firstOfLine && t.char <= char && !isClosing(t.kind):
+			if p.depth > depth {
+				p.depth = depth
+			}
+
+			return skipped
+
+		case isClosing(t.kind) && p.depth <= depth && p.depth > 0:
+			return skipped
+		}
+
+		skipped.add(p.leaf(p.Next()))
+	}
+}
+
+// Recovers from an error in the value of a let, when its body starts on a
+// line of its own where the let did
+func (p *parser) recoverLet(err error, start int, char int, depth int) (*Node, error) {
+	p.report(err)
+	skipped := p.skip(start, char, depth)
+
+	if t := p.Peek(); t.kind != TOKEN_KIND_EOF && t.firstOfLine && t.char == char && !isClosing(t.kind) {
+		return skipped, nil
+	}
+
+	return nil, err
+}
+
+// Recovers from an error in an arm of a pattern or a binding of a module,
+// when the braces close or the next one starts on a line indented past the
+// line opening them
+func (p *parser) recoverItem(err error, start int, char int, indent int, depth int) (*Node, error) {
+	p.report(err)
+	skipped := p.skip(start, char, depth)
+	t := p.Peek()
+
+	if t.kind == TOKEN_KIND_BRACE_CLOSE && p.depth == depth {
+		return skipped, nil
+	}
+
+	if t.kind != TOKEN_KIND_EOF && t.firstOfLine && t.char > indent && !isClosing(t.kind) {
+		return skipped, nil
+	}
+
+	return nil, err
+}
+
+// Where the first token of a line starts
+func (p *parser) indent(line int) int {
+	start := p.lineStarts[line-1]
+	i := start
+
+	for ; i < len(p.whole) && (p.whole[i] == ' ' || p.whole[i] == '\t'); i++ {
+	}
+
+	return i - start + 1
+}
+
+func (p *parser) Identifier() (*Node, error) {
 	if p.Peek().kind == TOKEN_KIND_IDENTIFIER {
-		return NewIdentifier(string(p.Next().value))
+		return newNode(NODE_KIND_IDENTIFIER, p.leaf(p.Next())), nil
 	}
 
 	return nil, errors.New("Cannot parse identifier")
 }
 
-func (p *parser) Module() (AST, error) {
-	if !p.ConsumeIfNext(TOKEN_KIND_MODULE) {
+func (p *parser) Module() (*Node, error) {
+	module := newNode(NODE_KIND_MODULE)
+
+	if !p.consume(module, TOKEN_KIND_MODULE) {
 		return nil, NewParseError(p, nil, "Module must begin with 'module'")
 	}
 
-	params := []AST{}
-
 	for p.Peek().kind != TOKEN_KIND_BRACE_OPEN {
 		id, err := p.Identifier()
 
@@ -457,119 +988,120 @@ func (p *parser) Module() (AST, error) {
 			return nil, NewParseError(p, err, "Failed to parse parameter id in module")
 		}
 
-		params = append(params, id)
+		module.add(id)
 	}
 
-	if !p.ConsumeIfNext(TOKEN_KIND_BRACE_OPEN) {
+	brace := p.Peek()
+
+	if !p.consume(module, TOKEN_KIND_BRACE_OPEN) {
 		return nil, NewParseError(p, nil, "Module must be surrounded by braces")
 	}
 
-	boundIds := []Identifier{}
-	boundValues := []AST{}
+	depth := p.depth
+	bindings := 0
 
-	for !p.ConsumeIfNext(TOKEN_KIND_BRACE_CLOSE) {
-		id, err := p.Identifier()
+	for !p.consume(module, TOKEN_KIND_BRACE_CLOSE) {
+		start := p.offset
+		char := p.Peek().char
+		binding, err := p.Binding()
 
 		if err != nil {
-			return nil, NewParseError(p, err, "Failed to parse bound id in module")
-		}
-
-		if !p.ConsumeIfNext(TOKEN_KIND_EQUAL) {
-			return nil, NewParseError(p, nil, "Bound id in module must be followed by '='")
-		}
-
-		value, err := p.Expression([]int{})
+			binding, err = p.recoverItem(err, start, char, p.indent(brace.line), depth)
 
-		if err != nil {
-			return nil, NewParseError(p, err, "Failed to parse bound value in module")
+			if err != nil {
+				return nil, err
+			}
 		}
 
-		boundIds = append(boundIds, id.(Identifier))
-		boundValues = append(boundValues, value)
+		module.add(binding)
+		bindings++
 	}
 
-	if len(boundIds) == 0 {
-		return nil, NewParseError(p, nil, "Module must have at least one bound value")
+	if bindings == 0 {
+		return nil, nodeParseError(module, nil, "Module must have at least one bound value")
 	}
 
-	matchGroups := [][]AST{}
-	bodies := []AST{}
+	return module, nil
+}
 
-	for _, id := range boundIds {
-		matchGroups = append(matchGroups, []AST{Label{id.Value}})
-		bodies = append(bodies, id)
-	}
+func (p *parser) Binding() (*Node, error) {
+	id, err := p.Identifier()
 
-	body, _ := NewPattern(matchGroups, bodies)
-	let, _ := NewLet(boundIds, boundValues, body)
+	if err != nil {
+		return nil, NewParseError(p, err, "Failed to parse bound id in module")
+	}
 
-	// Generate params pattern and let
-	if len(params) > 0 {
-		uniqueMatch := []AST{}
-		uniqueIds := []Identifier{}
-		moduleId := NextUniqueId()
+	binding := newNode(NODE_KIND_BINDING, id)
 
-		for _, param := range params {
-			uid := NextUniqueId()
-			uniqueMatch = append(uniqueMatch, uid)
-			uniqueIds = append(uniqueIds, param.(Identifier))
-		}
+	if !p.consume(binding, TOKEN_KIND_EQUAL) {
+		return nil, NewParseError(p, nil, "Bound id in module must be followed by '='")
+	}
 
-		paramLet, _ := NewLet(uniqueIds, uniqueMatch, Application{append([]AST{let, moduleId}, uniqueMatch...)})
-		paramPattern, _ := NewPattern([][]AST{append([]AST{moduleId}, uniqueMatch...)}, []AST{paramLet})
+	value, err := p.Expression([]int{})
 
-		return paramPattern, nil
-	} else {
-		return let, nil
+	if err != nil {
+		return nil, NewParseError(p, err, "Failed to parse bound value in module")
 	}
+
+	binding.add(value)
+
+	return binding, nil
 }
 
-func (p *parser) Label() (AST, error) {
+func (p *parser) Label() (*Node, error) {
 	if p.Peek().kind == TOKEN_KIND_LABEL {
-		return NewLabel(string(p.Next().value))
+		return newNode(NODE_KIND_LABEL, p.leaf(p.Next())), nil
 	}
 
 	return nil, errors.New("Cannot parse label")
 }
 
-func (p *parser) String() (AST, error) {
-	if p.Peek().kind == TOKEN_KIND_STRING {
-		str := string(p.Next().value)
+func (p *parser) String() (*Node, error) {
+	switch p.Peek().kind {
+	case TOKEN_KIND_STRING:
+		return newNode(NODE_KIND_STRING, p.leaf(p.Next())), nil
 
-		for i := 0; i < len(str); i++ {
-			switch {
-			case strings.HasPrefix(str[i:], "\\r"):
-				str = str[:i] + "\r" + str[i+2:]
-			case strings.HasPrefix(str[i:], "\\n"):
-				str = str[:i] + "\n" + str[i+2:]
-			case strings.HasPrefix(str[i:], "\\t"):
-				str = str[:i] + "\t" + str[i+2:]
+	// Text and the expressions interpolated in it take turns
+	case TOKEN_KIND_STRING_START:
+		str := newNode(NODE_KIND_STRING, p.leaf(p.Next()))
+
+		for {
+			expr, err := p.Expression([]int{TOKEN_KIND_STRING_MIDDLE, TOKEN_KIND_STRING_END})
+
+			if err != nil {
+				return nil, NewParseError(p, err, "Cannot parse interpolation in string")
 			}
-		}
 
-		return NewString(str)
-	}
+			str.add(expr)
 
-	return nil, errors.New("Cannot parse string")
-}
+			if p.consume(str, TOKEN_KIND_STRING_END) {
+				return str, nil
+			}
 
-func (p *parser) Number() (AST, error) {
-	if p.Peek().kind != TOKEN_KIND_NUMBER {
-		return nil, errors.New("Cannot parse number")
+			if !p.consume(str, TOKEN_KIND_STRING_MIDDLE) {
+				return nil, NewParseError(p, nil, "Interpolation in string must be closed by '}'")
+			}
+		}
 	}
 
-	str := p.Next().value
-	value := 0
+	return nil, errors.New("Cannot parse string")
+}
 
-	for i := 0; i < len(str); i++ {
-		value = value*10 + int(str[i]-'0')
+func (p *parser) Number() (*Node, error) {
+	switch p.Peek().kind {
+	case TOKEN_KIND_DECIMAL:
+		return newNode(NODE_KIND_DECIMAL, p.leaf(p.Next())), nil
+	case TOKEN_KIND_NUMBER:
+		return newNode(NODE_KIND_NUMBER, p.leaf(p.Next())), nil
 	}
 
-	return NewNumber(value)
+	return nil, errors.New("Cannot parse number")
 }
 
-func (p *parser) MatchExpr() (AST, error) {
-	if !p.ConsumeIfNext(TOKEN_KIND_MATCH) {
+func (p *parser) MatchExpr() (*Node, error) {
+	match := newNode(NODE_KIND_MATCH)
+
+	if !p.consume(match, TOKEN_KIND_MATCH) {
 		return nil, NewParseError(p, nil, "Match must begin with 'match'")
 	}
 
@@ -579,73 +1111,71 @@ func (p *parser) MatchExpr() (AST, error) {
 		return nil, NewParseError(p, err, "Cannot parse expression in match expression")
 	}
 
+	match.add(toMatch)
+
 	with, err := p.Pattern()
 
 	if err != nil {
 		return nil, NewParseError(p, err, "Cannot parse pattern in match expression")
 	}
 
-	return NewApplication([]AST{with, toMatch})
+	match.add(with)
+
+	return match, nil
 }
 
-func (p *parser) Let(identifier Identifier) (AST, error) {
-	if !p.ConsumeIfNext(TOKEN_KIND_EQUAL) {
+// The identifier has already been parsed, it began at start
+func (p *parser) Let(identifier *Node, start int) (*Node, error) {
+	let := newNode(NODE_KIND_LET, identifier)
+	depth := p.depth
+
+	if !p.consume(let, TOKEN_KIND_EQUAL) {
 		return nil, NewParseError(p, nil, ("'=' must follow identifier in let"))
 	}
 
 	value, err := p.Expression([]int{})
 
 	if err != nil {
-		return nil, NewParseError(p, err, ("Let must be assigned a value"))
+		value, err = p.recoverLet(err, start, identifier.Pos().Char, depth)
+
+		if err != nil {
+			return nil, NewParseError(p, err, ("Let must be assigned a value"))
+		}
 	}
 
+	let.add(value)
+
 	body, err := p.Expression([]int{})
 
 	if err != nil {
 		return nil, NewParseError(p, err, ("Let must have a body"))
 	}
 
-	// If the body is a let expression, merge them
-	switch b := body.(type) {
-	case Let:
-		return NewLet(
-			append([]Identifier{identifier}, b.BoundIds...),
-			append([]AST{value}, b.BoundValues...),
-			b.Body,
-		)
-	default:
-		return NewLet(
-			[]Identifier{identifier},
-			[]AST{value},
-			body,
-		)
-	}
-}
-
-func (p *parser) List(first AST) (AST, error) {
-	list := List{}
+	let.add(body)
 
-	// Because lists and list constructors share their starts
-	if first != nil {
-		list.Values = append(list.Values, first)
-	}
+	return let, nil
+}
 
+// Parses the rest of a list, its opening bracket and any first value and
+// comma have already been added to it
+func (p *parser) List(list *Node) (*Node, error) {
 	// Check for an empty list
-	if !p.ConsumeIfNext(TOKEN_KIND_BRACKET_CLOSE) {
+	if !p.consume(list, TOKEN_KIND_BRACKET_CLOSE) {
 		for {
 			val, err := p.Expression([]int{TOKEN_KIND_COMMA, TOKEN_KIND_BRACKET_CLOSE})
 
 			if err != nil {
 				return nil, NewParseError(p, err, ("Cannot parse expression in list"))
 			}
-			list.Values = append(list.Values, val)
 
-			if p.ConsumeIfNext(TOKEN_KIND_BRACKET_CLOSE) {
+			list.add(val)
+
+			if p.consume(list, TOKEN_KIND_BRACKET_CLOSE) {
 				break
 			}
 
 			// NOTE: !p.Peek().firstOfLine &&
-			if !p.ConsumeIfNext(TOKEN_KIND_COMMA) {
+			if !p.consume(list, TOKEN_KIND_COMMA) {
 				return nil, NewParseError(p, nil, ("Cannot parse comma in list"))
 			}
 		}
@@ -654,8 +1184,11 @@ func (p *parser) List(first AST) (AST, error) {
 	return list, nil
 }
 
-func (p *parser) ListConstructor(head AST) (AST, error) {
-	if !p.ConsumeIfNext(TOKEN_KIND_COLON) {
+// Parses the rest of a list constructor after its head
+func (p *parser) ListConstructor(list *Node) (*Node, error) {
+	list.Kind = NODE_KIND_LIST_CONSTRUCTOR
+
+	if !p.consume(list, TOKEN_KIND_COLON) {
 		return nil, NewParseError(p, nil, ("List constructors must have a colon ':' separating the head and tail expressions"))
 	}
 
@@ -665,27 +1198,23 @@ func (p *parser) ListConstructor(head AST) (AST, error) {
 		return nil, NewParseError(p, err, ("Cannot parse expression in list constructor"))
 	}
 
-	if !p.ConsumeIfNext(TOKEN_KIND_BRACKET_CLOSE) {
+	list.add(tail)
+
+	if !p.consume(list, TOKEN_KIND_BRACKET_CLOSE) {
 		return nil, NewParseError(p, nil, ("List constructors must be enclosed by brackets '[' ']'"))
 	}
 
-	return ListConstructor{Head: head, Tail: tail}, nil
+	return list, nil
 }
 
-func (p *parser) Where(match AST) (AST, error) {
-	constantTime := false
+func (p *parser) Where(match *Node) (*Node, error) {
+	where := newNode(NODE_KIND_WHERE, match)
 
-	switch p.Peek().kind {
-	case TOKEN_KIND_COLON:
-		p.Next()
-	case TOKEN_KIND_DOUBLE_COLON:
-		p.Next()
-		constantTime = true
-	default:
+	if !p.consume(where, TOKEN_KIND_COLON) && !p.consume(where, TOKEN_KIND_DOUBLE_COLON) {
 		return nil, NewParseError(p, nil, ("Where must start with a colon"))
 	}
 
-	if !p.ConsumeIfNext(TOKEN_KIND_PAREN_OPEN) {
+	if !p.consume(where, TOKEN_KIND_PAREN_OPEN) {
 		return nil, NewParseError(p, nil, ("Where body must be enclosed by parenthesis '(' ')'"))
 	}
 
@@ -695,92 +1224,114 @@ func (p *parser) Where(match AST) (AST, error) {
 		return nil, NewParseError(p, err, ("Cannot parse expression in where"))
 	}
 
-	if !p.ConsumeIfNext(TOKEN_KIND_PAREN_CLOSE) {
+	where.add(body)
+
+	if !p.consume(where, TOKEN_KIND_PAREN_CLOSE) {
 		return nil, NewParseError(p, nil, ("Where body must be enclosed by parenthesis '(' ')'"))
 	}
 
-	return NewWhere(match, body, constantTime)
+	return where, nil
 }
 
-func (p *parser) Pattern() (AST, error) {
-	if !p.ConsumeIfNext(TOKEN_KIND_BRACE_OPEN) {
+func (p *parser) Pattern() (*Node, error) {
+	pattern := newNode(NODE_KIND_PATTERN)
+	brace := p.Peek()
+
+	if !p.consume(pattern, TOKEN_KIND_BRACE_OPEN) {
 		return nil, NewParseError(p, nil, ("Pattern must be enclosed by braces '{' '}'"))
 	}
 
-	matchBodies := [][]AST{}
-	bodies := []AST{}
+	depth := p.depth
+	arms := []*Node{}
 
-	for !p.ConsumeIfNext(TOKEN_KIND_BRACE_CLOSE) {
-		matches := []AST{}
-		isImplicitBody := false
+	for !p.consume(pattern, TOKEN_KIND_BRACE_CLOSE) {
+		start := p.offset
+		char := p.Peek().char
+		arm, err := p.Arm(arms, len(pattern.nodes()))
 
-		if p.ConsumeIfNext(TOKEN_KIND_FAT_ARROW) {
-			if len(matchBodies) == 0 {
-				return nil, NewParseError(p, nil, ("Default pattern match cannot be the first body"))
-			}
+		if err != nil {
+			skipped, err := p.recoverItem(err, start, char, p.indent(brace.line), depth)
 
-			for range matchBodies[0] {
-				id, _ := NewIdentifier("_")
-				matches = append(matches, id)
+			if err != nil {
+				return nil, err
 			}
-		} else {
-			for !p.ConsumeIfNext(TOKEN_KIND_ARROW) {
-				match, err := p.Match()
 
-				if err != nil {
-					return nil, NewParseError(p, err, ("Cannot parse match in pattern"))
-				}
-
-				matches = append(matches, match)
-
-				if p.Peek().kind == TOKEN_KIND_BRACE_CLOSE {
-					isImplicitBody = true
-					break
-				}
-			}
+			pattern.add(skipped)
+			continue
 		}
 
-		// Add missing values for implicit body
-		if isImplicitBody {
-			if len(matchBodies) != 0 {
-				return nil, NewParseError(p, nil, ("Pattern can only have one implicit true match"))
-			}
+		pattern.add(arm)
+		arms = append(arms, arm)
+	}
 
-			falseMatches := []AST{}
+	return pattern, nil
+}
 
-			for range matches {
-				id, _ := NewIdentifier("_")
-				falseMatches = append(falseMatches, id)
-			}
+// An arm following the arms before it, count includes those that failed
+func (p *parser) Arm(arms []*Node, count int) (*Node, error) {
+	arm := newNode(NODE_KIND_ARM)
+	matches := 0
 
-			bodies = append(bodies, True)
-			bodies = append(bodies, False)
-			matchBodies = append(matchBodies, matches)
-			matchBodies = append(matchBodies, falseMatches)
+	if p.consume(arm, TOKEN_KIND_FAT_ARROW) {
+		if count == 0 {
+			return nil, nodeParseError(arm, nil, ("Default pattern match cannot be the first body"))
+		}
 
-		} else {
-			// TODO: revisit cases
-			body, err := p.Expression([]int{TOKEN_KIND_BRACE_CLOSE})
+		arm.Kind = NODE_KIND_DEFAULT_ARM
+
+		if len(arms) > 0 {
+			matches = len(arms[0].nodes()) - 1
+		}
+	} else {
+		for !p.consume(arm, TOKEN_KIND_ARROW) {
+			match, err := p.Match()
 
 			if err != nil {
-				return nil, NewParseError(p, err, ("Cannot parse expression in pattern"))
+				return nil, NewParseError(p, err, ("Cannot parse match in pattern"))
 			}
 
-			if len(matchBodies) > 0 && len(matches) != len(matchBodies[0]) {
-				return nil, NewParseError(p, err, ("Pattern cannot take varying arguments"))
+			arm.add(match)
+			matches++
+
+			if p.Peek().kind == TOKEN_KIND_BRACE_CLOSE {
+				arm.Kind = NODE_KIND_IMPLICIT_ARM
+				break
 			}
+		}
 
-			matchBodies = append(matchBodies, matches)
-			bodies = append(bodies, body)
+		if matches == 0 {
+			return nil, nodeParseError(arm, nil, ("Pattern must match at least one value"))
 		}
 	}
 
-	return NewPattern(matchBodies, bodies)
+	// Implicit bodies are added when lowering
+	if arm.Kind == NODE_KIND_IMPLICIT_ARM {
+		if count != 0 {
+			return nil, nodeParseError(arm, nil, ("Pattern can only have one implicit true match"))
+		}
+
+		return arm, nil
+	}
+
+	// TODO: revisit cases
+	body, err := p.Expression([]int{TOKEN_KIND_BRACE_CLOSE})
+
+	if err != nil {
+		return nil, NewParseError(p, err, ("Cannot parse expression in pattern"))
+	}
+
+	arm.add(body)
+
+	if len(arms) > 0 && matches != len(arms[0].nodes())-1 {
+		return nil, nodeParseError(arm, nil, ("Pattern cannot take varying arguments"))
+	}
+
+	return arm, nil
 }
 
 // REFACTOR: where should apply to all match exprs
-func (p *parser) Match() (AST, error) {
-	var match AST
+func (p *parser) Match() (*Node, error) {
+	var match *Node
 	var err error
 
 	switch p.Peek().kind {
@@ -805,7 +1356,7 @@ func (p *parser) Match() (AST, error) {
 			return nil, NewParseError(p, err, ("Cannot parse string in match"))
 		}
 
-	case TOKEN_KIND_NUMBER:
+	case TOKEN_KIND_NUMBER, TOKEN_KIND_DECIMAL:
 		match, err = p.Number()
 
 		if err != nil {
@@ -814,10 +1365,10 @@ func (p *parser) Match() (AST, error) {
 
 	// Lists and list constructors: open bracket and an expression
 	case TOKEN_KIND_BRACKET_OPEN:
-		p.Next()
+		list := newNode(NODE_KIND_LIST, p.leaf(p.Next()))
 
 		if p.Peek().kind == TOKEN_KIND_BRACKET_CLOSE {
-			match, err = p.List(nil)
+			match, err = p.List(list)
 
 			if err != nil {
 				return nil, NewParseError(p, err, ("Cannot parse list in match"))
@@ -836,24 +1387,32 @@ func (p *parser) Match() (AST, error) {
 			return nil, NewParseError(p, err, ("Cannot parse expression in match"))
 		}
 
+		list.add(expr)
+
 		if p.Peek().kind == TOKEN_KIND_COLON {
-			match, err = p.ListConstructor(expr)
+			match, err = p.ListConstructor(list)
 
 			if err != nil {
 				return nil, NewParseError(p, err, ("Cannot parse list constructor in match"))
 			}
-		} else if p.ConsumeIfNext(TOKEN_KIND_COMMA) {
-			match, err = p.List(expr)
+		} else if p.consume(list, TOKEN_KIND_COMMA) {
+			match, err = p.List(list)
 
 			if err != nil {
 				return nil, NewParseError(p, err, ("Cannot parse list in match"))
 			}
-		} else if p.ConsumeIfNext(TOKEN_KIND_BRACKET_CLOSE) {
-			match = List{Values: []AST{expr}}
+		} else if p.consume(list, TOKEN_KIND_BRACKET_CLOSE) {
+			match = list
 		} else {
 			return nil, NewParseError(p, nil, ("Unable to parse list or list constructor in match expression"))
 		}
 
+	case TOKEN_KIND_ERROR:
+		return nil, NewParseError(p, nil, string(p.Peek().value))
+
+	case TOKEN_KIND_STRING_START:
+		return nil, NewParseError(p, nil, ("Interpolated strings cannot be matched"))
+
 	default:
 		return nil, NewParseError(p, nil, ("Unexpected error occured when parsing match"))
 	}
@@ -872,12 +1431,13 @@ func (p *parser) Match() (AST, error) {
 	return match, nil
 }
 
-func (p *parser) PrimaryExpr(endTokenKinds []int) (AST, error) {
+func (p *parser) PrimaryExpr(endTokenKinds []int) (*Node, error) {
 	if p.Peek().kind == TOKEN_KIND_IDENTIFIER {
-		id, _ := NewIdentifier(string(p.Next().value))
+		start := p.offset
+		id := newNode(NODE_KIND_IDENTIFIER, p.leaf(p.Next()))
 
 		if p.Peek().kind == TOKEN_KIND_EQUAL {
-			return p.Let(id)
+			return p.Let(id, start)
 		} else {
 			return id, nil
 		}
@@ -891,11 +1451,11 @@ func (p *parser) PrimaryExpr(endTokenKinds []int) (AST, error) {
 		return p.Label()
 	}
 
-	if p.Peek().kind == TOKEN_KIND_STRING {
+	if p.Peek().kind == TOKEN_KIND_STRING || p.Peek().kind == TOKEN_KIND_STRING_START {
 		return p.String()
 	}
 
-	if p.Peek().kind == TOKEN_KIND_NUMBER {
+	if p.Peek().kind == TOKEN_KIND_NUMBER || p.Peek().kind == TOKEN_KIND_DECIMAL {
 		return p.Number()
 	}
 
@@ -908,32 +1468,64 @@ func (p *parser) PrimaryExpr(endTokenKinds []int) (AST, error) {
 	}
 
 	if p.Peek().kind == TOKEN_KIND_PAREN_OPEN {
-		p.Next()
+		parens := newNode(NODE_KIND_PARENS, p.leaf(p.Next()))
 		res, err := p.Expression([]int{TOKEN_KIND_PAREN_CLOSE})
 
 		if err != nil {
 			return nil, NewParseError(p, err, ("Cannot parse expression in primary expression"))
 		}
 
-		if !p.ConsumeIfNext(TOKEN_KIND_PAREN_CLOSE) {
+		parens.add(res)
+
+		if !p.consume(parens, TOKEN_KIND_PAREN_CLOSE) {
 			return nil, NewParseError(p, nil, ("Application which starts with an open parenthesis must close with one"))
 		}
 
-		return res, nil
+		return parens, nil
 	}
 
 	// Lists and list constructors: open bracket and an expression
 	if p.Peek().kind == TOKEN_KIND_BRACKET_OPEN {
-		p.Next()
-		return p.List(nil)
+		list := newNode(NODE_KIND_LIST, p.leaf(p.Next()))
+
+		if p.Peek().kind == TOKEN_KIND_BRACKET_CLOSE {
+			return p.List(list)
+		}
+
+		if p.Peek().kind == TOKEN_KIND_COLON {
+			return nil, NewParseError(p, nil, ("List constructor cannot have an empty head value"))
+		}
+
+		expr, err := p.Expression([]int{TOKEN_KIND_COLON, TOKEN_KIND_COMMA, TOKEN_KIND_BRACKET_CLOSE})
+
+		if err != nil {
+			return nil, NewParseError(p, err, ("Cannot parse expression in list"))
+		}
+
+		list.add(expr)
+
+		if p.Peek().kind == TOKEN_KIND_COLON {
+			return p.ListConstructor(list)
+		} else if p.consume(list, TOKEN_KIND_COMMA) {
+			return p.List(list)
+		} else if p.consume(list, TOKEN_KIND_BRACKET_CLOSE) {
+			return list, nil
+		}
+
+		return nil, NewParseError(p, nil, ("Unable to parse list or list constructor in expression"))
+	}
+
+	// Strings that can't be scanned explain why
+	if t := p.Peek(); t.kind == TOKEN_KIND_ERROR {
+		return nil, NewParseError(p, nil, string(t.value))
 	}
 
 	return nil, NewParseError(p, nil, "Unexpected error occured when parsing an expression")
 }
 
-func (p *parser) OpExpr(precedence int, endTokenKinds []int) (AST, error) {
+func (p *parser) OpExpr(precedence int, endTokenKinds []int) (*Node, error) {
 	if precedence >= len(opPrecedence) {
-		exprs := []AST{}
+		app := newNode(NODE_KIND_APPLICATION)
 
 		for {
 			next, err := p.PrimaryExpr(endTokenKinds)
@@ -942,7 +1534,7 @@ func (p *parser) OpExpr(precedence int, endTokenKinds []int) (AST, error) {
 				return nil, NewParseError(p, err, ("Cannot parse primary expression in op expression"))
 			}
 
-			exprs = append(exprs, next)
+			app.add(next)
 
 			// Parse for end of op
 			end := false
@@ -963,17 +1555,17 @@ func (p *parser) OpExpr(precedence int, endTokenKinds []int) (AST, error) {
 			}
 		}
 
-		if len(exprs) == 0 {
+		if len(app.Children) == 0 {
 			return nil, NewParseError(p, nil, ("Cannot parse primary expression"))
 		}
 
-		if len(exprs) == 1 {
-			return exprs[0], nil
+		if len(app.Children) == 1 {
+			return app.Children[0], nil
 		}
 
-		return Application{Body: exprs}, nil
+		return app, nil
 	} else {
-		var head AST
+		var head *Node
 		var err error
 		head, err = p.OpExpr(precedence+1, append(append([]int{}, opPrecedence[precedence]...), endTokenKinds...))
 
@@ -1002,7 +1594,7 @@ func (p *parser) OpExpr(precedence int, endTokenKinds []int) (AST, error) {
 			for _, token := range opPrecedence[precedence] {
 				if p.Peek().kind == token {
 					foundOp = true
-					t := p.Next()
+					op := p.leaf(p.Next())
 
 					next, err := p.OpExpr(precedence+1, append(append([]int{}, opPrecedence[precedence]...), endTokenKinds...))
 
@@ -1010,7 +1602,7 @@ func (p *parser) OpExpr(precedence int, endTokenKinds []int) (AST, error) {
 						return nil, NewParseError(p, err, ("Cannot parse op expression in primary expression"))
 					}
 
-					head = Application{Body: []AST{Identifier{Value: string(t.value)}, head, next}}
+					head = newNode(NODE_KIND_OPERATION, head, op, next)
 					break
 				}
 			}
@@ -1024,7 +1616,7 @@ func (p *parser) OpExpr(precedence int, endTokenKinds []int) (AST, error) {
 	}
 }
 
-func (p *parser) Expression(endTokenKinds []int) (AST, error) {
+func (p *parser) Expression(endTokenKinds []int) (*Node, error) {
 	// Start parsing with default ender tokens
 	expr, err := p.OpExpr(0, append(endTokenKinds, []int{TOKEN_KIND_EOF, TOKEN_KIND_SEMI_COLON}...))
 
@@ -1032,77 +1624,228 @@ func (p *parser) Expression(endTokenKinds []int) (AST, error) {
 		return nil, NewParseError(p, err, ("Cannot parse op expression in expression"))
 	}
 
+	// The semicolon is kept with what it ends
 	if p.Peek().kind == TOKEN_KIND_SEMI_COLON {
-		p.Next()
+		expr.add(p.leaf(p.Next()))
 	}
 
 	return expr, nil
 }
 
-func Parse(src []byte) (*SourceFile, error) {
+func newParser(file string, src []byte) *parser {
+	registerSource(file, src)
+
 	p := &parser{
+		file,
 		src,
 		0,
 		0,
 		[numberOfSavedLines][]byte{},
 		[]byte{},
+		false,
+		src,
+		[]int{0},
+		0,
+		0,
+		[]*ParseError{},
+		nil,
 	}
 
 	for i := range p.savedLines {
 		p.savedLines[i] = []byte{}
 	}
 
+	for i, c := range src {
+		if c == '\n' {
+			p.lineStarts = append(p.lineStarts, i+1)
+		}
+	}
+
+	return p
+}
+
+// Parses a single statement without a package header, as entered in the repl.
+// A top level binding 'id = value' has no body, so the bound identifier is
+// returned alongside its value. Any other statement returns a nil identifier.
+func ParseStatement(file string, src []byte) (*Identifier, AST, error) {
+	p := newParser(file, src)
+
+	// Nothing but whitespace and comments
+	if p.Peek().kind == TOKEN_KIND_EOF {
+		return nil, nil, nil
+	}
+
+	if p.Peek().kind == TOKEN_KIND_IDENTIFIER {
+		saved := *p
+		binding := newNode(NODE_KIND_BINDING, newNode(NODE_KIND_IDENTIFIER, p.leaf(p.Next())))
+
+		if p.consume(binding, TOKEN_KIND_EQUAL) {
+			value, err := p.Expression([]int{})
+
+			if err != nil {
+				return nil, nil, p.failed(NewParseError(p, err, "Cannot parse bound value"))
+			}
+
+			if p.Peek().kind == TOKEN_KIND_EOF {
+				if len(p.diagnostics) > 0 {
+					return nil, nil, ParseErrors(p.diagnostics)
+				}
+
+				id := lowerIdentifier(binding.Children[0])
+				ast, err := Lower(value)
+
+				if err != nil {
+					return nil, nil, err
+				}
+
+				return &id, ast, nil
+			}
+		}
+
+		// Not a lone binding, reparse as an expression
+		*p = saved
+	}
+
+	expr, err := p.Expression([]int{})
+
+	if err != nil {
+		return nil, nil, p.failed(NewParseError(p, err, "Cannot parse statement"))
+	}
+
+	if p.Peek().kind != TOKEN_KIND_EOF {
+		return nil, nil, p.failed(NewParseError(p, nil, "Unexpected end of parsing"))
+	}
+
+	if len(p.diagnostics) > 0 {
+		return nil, nil, ParseErrors(p.diagnostics)
+	}
+
+	ast, err := Lower(expr)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return nil, ast, nil
+}
+
+// Parses a source file into its concrete syntax tree, see Node. Parsing
+// carries on after errors where it can, the error returned is then the
+// ParseErrors found.
+func ParseCST(fileName string, src []byte) (*Node, error) {
+	p := newParser(fileName, src)
+	file := newNode(NODE_KIND_FILE)
+
 	// Parse package then imports
-	if !p.ConsumeIfNext(TOKEN_KIND_PACKAGE) {
-		return nil, NewParseError(p, nil, "Must begin with a package name")
+	if !p.consume(file, TOKEN_KIND_PACKAGE) {
+		return nil, p.failed(NewParseError(p, nil, "Must begin with a package name"))
 	}
 
 	if p.Peek().kind != TOKEN_KIND_IDENTIFIER {
-		return nil, NewParseError(p, nil, "Package name must be an identifier")
+		return nil, p.failed(NewParseError(p, nil, "Package name must be an identifier"))
 	}
 
-	file := &SourceFile{}
-	file.PackageName = string(p.Next().value)
+	file.add(newNode(NODE_KIND_IDENTIFIER, p.leaf(p.Next())))
 
 	// Parse all imports
-	for p.ConsumeIfNext(TOKEN_KIND_IMPORT) {
-		if p.Peek().kind != TOKEN_KIND_STRING {
-			return nil, NewParseError(p, nil, "Import path must be a string")
+	for p.Peek().kind == TOKEN_KIND_IMPORT {
+		imp, err := p.Import()
+
+		if err != nil {
+			return nil, p.failed(err)
 		}
 
-		path := string(p.Next().value)
+		file.add(imp)
+	}
 
-		if len(path) < 3 || path[len(path)-3:] != ".sl" {
-			return nil, NewParseError(p, nil, "Invalid path string specified")
-		}
+	expr, err := p.Expression([]int{})
 
-		name := ""
+	if err != nil {
+		return nil, p.failed(NewParseError(p, err, "Cannot parse base expression"))
+	}
 
-		/* Import renaming removed for now
-		if p.Peek().kind == TOKEN_KIND_IDENTIFIER {
-			return nil, NewParseError(p, nil, "Import name must be an identifier")
-		}
+	file.add(expr)
 
-		name = string(p.Next().value)
+	if end := p.Peek(); end.kind != TOKEN_KIND_EOF {
+		return nil, p.failed(NewParseError(p, nil, fmt.Sprintf("[%d:%d] Unexpected end of parsing", end.line, end.char)))
+	}
 
-		if name == "_" {
-			return nil, NewParseError(p, nil, "Import name can't be discarded (_)")
-		}*/
+	file.add(p.leaf(p.Next()))
 
-		file.Imports = append(file.Imports, SourceFileImport{path, name})
+	if len(p.diagnostics) > 0 {
+		return nil, ParseErrors(p.diagnostics)
 	}
 
-	ast, err := p.Expression([]int{})
+	return file, nil
+}
 
-	if err != nil {
-		return nil, NewParseError(p, err, "Cannot parse base expression")
+func (p *parser) Import() (*Node, error) {
+	imp := newNode(NODE_KIND_IMPORT, p.leaf(p.Next()))
+
+	if p.Peek().kind != TOKEN_KIND_STRING {
+		return nil, NewParseError(p, nil, "Import path must be a string")
 	}
 
-	if p.Next().kind != TOKEN_KIND_EOF {
-		return nil, NewParseError(p, nil, fmt.Sprintf("[%d:%d] Unexpected end of parsing", p.line, p.char))
+	path := newNode(NODE_KIND_STRING, p.leaf(p.Next()))
+
+	// Either a source file or a package name, like "std"
+	if ext := filepath.Ext(path.Children[0].Token.Value); path.Children[0].Token.Value == "" || (ext != "" && ext != ".sl") {
+		return nil, nodeParseError(path, nil, "Invalid path string specified")
 	}
 
-	file.Definition = ast
+	imp.add(path)
 
-	return file, nil
+	// Renaming must follow the path on its line, otherwise 'as' begins
+	// the expression
+	if next := p.Peek(); next.kind == TOKEN_KIND_IDENTIFIER && string(next.value) == "as" && !next.firstOfLine {
+		saved := *p
+		as := p.leaf(p.Next())
+
+		if p.Peek().kind == TOKEN_KIND_IDENTIFIER && !p.Peek().firstOfLine {
+			name := newNode(NODE_KIND_IDENTIFIER, p.leaf(p.Next()))
+
+			if name.Children[0].Token.Value == "_" {
+				return nil, nodeParseError(name, nil, "Import name can't be discarded (_)")
+			}
+
+			imp.add(as, name)
+		} else {
+			*p = saved
+		}
+	}
+
+	// So must the names selected from the package
+	if next := p.Peek(); next.kind == TOKEN_KIND_PAREN_OPEN && !next.firstOfLine {
+		names := newNode(NODE_KIND_PARENS, p.leaf(p.Next()))
+
+		for !p.consume(names, TOKEN_KIND_PAREN_CLOSE) {
+			if len(names.nodes()) > 0 && !p.consume(names, TOKEN_KIND_COMMA) {
+				return nil, NewParseError(p, nil, "Imported names must be separated by commas")
+			}
+
+			if p.Peek().kind != TOKEN_KIND_IDENTIFIER {
+				return nil, NewParseError(p, nil, "Imported name must be an identifier")
+			}
+
+			names.add(newNode(NODE_KIND_IDENTIFIER, p.leaf(p.Next())))
+		}
+
+		if len(names.nodes()) == 0 {
+			return nil, nodeParseError(names, nil, "Import must select at least one name")
+		}
+
+		imp.add(names)
+	}
+
+	return imp, nil
+}
+
+func Parse(fileName string, src []byte) (*SourceFile, error) {
+	file, err := ParseCST(fileName, src)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return LowerFile(file)
 }
@@ -0,0 +1,95 @@
+package ast
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// Parses and evaluates a file made of the expression
+func evalExpression(expr string) (AST, error) {
+	file, err := Parse("expression.sl", []byte("package expression\n\nn = 41\n\n"+expr+"\n"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	lib := DefaultBuiltins().Let()
+	lib.Body = file.Definition
+
+	return lib.Eval(NewLimitedEnv(NewBudget(context.Background(), Limits{})))
+}
+
+func TestStrings(t *testing.T) {
+	tests := []struct {
+		src      string
+		expected string
+	}{
+		{`"h\u{e9}"`, "hé"},
+		{`"tab\tquote\" slash\\"`, "tab\tquote\" slash\\"},
+		{`"caf\u{e9} \u{1F600}\n"`, "café 😀\n"},
+		{`r"C:\n\u{e9}"`, `C:\n\u{e9}`},
+		{"\"\"\"\n  multi \\u{e9}\n    line\n  \"\"\"", "multi é\n  line"},
+		{"r\"\"\"\n  raw \\n\n  \"\"\"", `raw \n`},
+		{`"braces \{ and \} and a lone }"`, "braces { and } and a lone }"},
+		{`r"C:\{n}\u{e9}"`, `C:\{n}\u{e9}`},
+		{`"value: {n + 1}"`, "value: 42"},
+		{`"caf\u{e9} {n} \u{1F600}\n"`, "café 41 😀\n"},
+		{`"\{{n}\}"`, "{41}"},
+		{`"nested {"inner {n}"} {[n, .a]}"`, "nested inner 41 [ 41, .a ]"},
+		{"\"\"\"\n  multi \\u{e9} {n}\n    line\n  \"\"\"", "multi é 41\n  line"},
+	}
+
+	for _, test := range tests {
+		res, err := evalExpression(test.src)
+
+		if err != nil {
+			t.Errorf("%s: %v", test.src, err)
+			continue
+		}
+
+		if !res.Equals(String{Value: test.expected}) {
+			t.Errorf("%s: expected %q, got %s", test.src, test.expected, strings.Join(res.String(), "\n"))
+		}
+	}
+}
+
+func TestStringErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{`"unclosed`, "String must be closed on the line it begins"},
+		{"\"\"\"\n  unclosed", `Multi-line string must be closed by triple quotes`},
+		{`"\q"`, `Unknown escape sequence '\q' in string`},
+		{`"\u{zz}"`, `Invalid unicode escape '\u{zz}'`},
+		{`"\u00e9"`, `Unicode escapes must be written as \u{...}`},
+		{`"a{"`, "Interpolation in string must be closed by '}'"},
+		{`"\u{zz} {n}"`, `Invalid unicode escape '\u{zz}'`},
+		{`{ "{n}" -> 1 }`, "Interpolated strings cannot be matched"},
+	}
+
+	for _, test := range tests {
+		_, err := evalExpression(test.src)
+
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected an error containing %q, got %v", test.src, test.err, err)
+		}
+	}
+}
+
+// Printed strings read back as the same string
+func TestQuotedStringsReadBack(t *testing.T) {
+	for _, s := range []string{"multi {n}", "} and {", "é\n\t\"\\", "\x01"} {
+		res, err := evalExpression(quote(s))
+
+		if err != nil {
+			t.Errorf("%q: %v", s, err)
+			continue
+		}
+
+		if !res.Equals(String{Value: s}) {
+			t.Errorf("%q read back as %s", s, strings.Join(res.String(), "\n"))
+		}
+	}
+}
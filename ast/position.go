@@ -0,0 +1,119 @@
+package ast
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Location of a node in its source file, lines and chars start at 1
+type Position struct {
+	File string
+	Line int
+	Char int
+}
+
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Char)
+	}
+
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Char)
+}
+
+// Parsed sources are kept so runtime errors can display the offending lines
+var sources = map[string][][]byte{}
+var sourcesLock sync.Mutex
+
+func registerSource(file string, src []byte) {
+	sourcesLock.Lock()
+	defer sourcesLock.Unlock()
+
+	sources[file] = bytes.Split(src, []byte("\n"))
+}
+
+// Forgets a source, errors raised from it afterwards no longer display its
+// lines
+func ReleaseSource(file string) {
+	sourcesLock.Lock()
+	defer sourcesLock.Unlock()
+
+	delete(sources, file)
+}
+
+// Lines leading up to and including the position, oldest first
+func sourceLines(pos Position) [][]byte {
+	sourcesLock.Lock()
+	defer sourcesLock.Unlock()
+
+	lines, ok := sources[pos.File]
+
+	if !ok || pos.Line > len(lines) {
+		return nil
+	}
+
+	res := [][]byte{}
+
+	for i := pos.Line - numberOfSavedLines; i < pos.Line; i++ {
+		if i < 0 {
+			res = append(res, []byte{})
+		} else {
+			res = append(res, bytes.TrimRight(lines[i], "\r"))
+		}
+	}
+
+	return res
+}
+
+/*
+Formatted like:
+
+	  | package sketch
+	  |
+	3 | test = {
+	         ^
+	  ERROR: msg
+*/
+func sourceDisplay(line int, char int, lines [][]byte, err string) string {
+	return sourceSpanDisplay(line, char, 1, lines, err)
+}
+
+// Underlines width chars from the position rather than pointing at it
+func sourceSpanDisplay(line int, char int, width int, lines [][]byte, err string) string {
+	lineNum := fmt.Sprintf("%d", line)
+	message := "\n"
+	padStr := pad(len(lineNum) + 2)
+
+	for i, l := range lines {
+		if i == len(lines)-1 {
+			message += fmt.Sprintf(" %s ", lineNum)
+		} else {
+			message += padStr
+		}
+
+		message += fmt.Sprintf("| %s\n", string(l))
+	}
+
+	message += padStr + pad(char+1) + strings.Repeat("^", width)
+	message += "\n" + padStr
+	message += fmt.Sprintf("ERROR: %s\n", err)
+
+	return message
+}
+
+// Displays the lines leading up to a position, empty when the source isn't
+// known
+func SourceDisplay(pos Position, err string) string {
+	lines := sourceLines(pos)
+
+	if lines == nil {
+		return ""
+	}
+
+	return sourceDisplay(pos.Line, pos.Char, lines, err)
+}
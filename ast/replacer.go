@@ -43,6 +43,8 @@ func (v *Replacer) Visit(a AST) (AST, error) {
 		return v.VisitListConstructor(A)
 	case Number:
 		return a, nil
+	case Decimal:
+		return a, nil
 	case Let:
 		return v.VisitLet(A)
 	case Where:
@@ -0,0 +1,214 @@
+package ast
+
+import (
+	"fmt"
+	"io"
+)
+
+// The sys package, importable as `import "sys"`. Its members read and write
+// through the effects of the program, and return [.success, v] or
+// [.fail, message] like data.error rather than stopping the program.
+func SysPackage() AST {
+	matchGroups := [][]AST{}
+	bodies := []AST{}
+
+	for _, m := range sysMembers {
+		matchGroups = append(matchGroups, []AST{Label{Value: m.name}})
+		bodies = append(bodies, m.value)
+	}
+
+	pattern, _ := NewPattern(matchGroups, bodies)
+	res, _ := pattern.Eval(NewEnv(nil))
+
+	return res
+}
+
+type sysMember struct {
+	name  string
+	value AST
+}
+
+// Constant time guards can't apply sys builtins either
+func init() {
+	for _, m := range sysMembers {
+		if B, ok := m.value.(Builtin); ok {
+			effectfulBuiltins[B.name] = true
+		}
+	}
+}
+
+func sysSuccess(v AST) AST {
+	return List{Values: []AST{Label{Value: "success"}, v}}
+}
+
+func sysFail(msg string) AST {
+	return List{Values: []AST{Label{Value: "fail"}, String{Value: msg}}}
+}
+
+func stringList(strs []string) List {
+	res := List{Values: []AST{}}
+
+	for _, s := range strs {
+		res.Values = append(res.Values, String{Value: s})
+	}
+
+	return res
+}
+
+// Stdin is the only stream sys reads lines from, naming it keeps the members
+// taking an argument like every other builtin
+func sysStream(args Args) error {
+	label, err := args.Label(0)
+
+	if err == nil && label != "stdin" {
+		err = args.expected(0, ".stdin")
+	}
+
+	return err
+}
+
+var sysArgs = NewBuiltin("sys.args", 1, func(args Args) (AST, error) {
+	return stringList(args.Effects().Args), nil
+})
+
+var sysMembers = []sysMember{
+	// Read when the member is used, so it's the args of the running program
+	{"args", Application{Body: []AST{sysArgs, Label{Value: "args"}}}},
+
+	{"read_line", NewBuiltin("sys.read_line", 1, func(args Args) (AST, error) {
+		if err := sysStream(args); err != nil {
+			return nil, err
+		}
+
+		line, err := args.Effects().ReadLine()
+
+		switch {
+		case err == io.EOF:
+			return sysFail("End of input"), nil
+		case err != nil:
+			return sysFail(err.Error()), nil
+		}
+
+		return sysSuccess(String{Value: line}), nil
+	})},
+
+	{"read_lines", NewBuiltin("sys.read_lines", 1, func(args Args) (AST, error) {
+		if err := sysStream(args); err != nil {
+			return nil, err
+		}
+
+		lines := []string{}
+
+		for {
+			line, err := args.Effects().ReadLine()
+
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				return sysFail(err.Error()), nil
+			}
+
+			lines = append(lines, line)
+		}
+
+		return sysSuccess(stringList(lines)), nil
+	})},
+
+	{"read_file", NewBuiltin("sys.read_file", 1, func(args Args) (AST, error) {
+		path, err := args.String(0)
+
+		if err != nil {
+			return nil, err
+		}
+
+		fs := args.Effects().FS
+
+		if fs == nil {
+			return sysFail("Can't read files without a file system"), nil
+		}
+
+		data, err := fs.ReadFile(path)
+
+		if err != nil {
+			return sysFail(err.Error()), nil
+		}
+
+		if err := args.env.Budget().Allocate(len(data)); err != nil {
+			return nil, err
+		}
+
+		return sysSuccess(String{Value: string(data)}), nil
+	})},
+
+	{"write_file", NewBuiltin("sys.write_file", 2, func(args Args) (AST, error) {
+		path, err := args.String(0)
+
+		if err != nil {
+			return nil, err
+		}
+
+		contents, err := args.String(1)
+
+		if err != nil {
+			return nil, err
+		}
+
+		fs := args.Effects().FS
+
+		if fs == nil {
+			return sysFail("Can't write files without a file system"), nil
+		}
+
+		if err := fs.WriteFile(path, []byte(contents)); err != nil {
+			return sysFail(err.Error()), nil
+		}
+
+		return sysSuccess(String{Value: path}), nil
+	})},
+
+	{"list_dir", NewBuiltin("sys.list_dir", 1, func(args Args) (AST, error) {
+		path, err := args.String(0)
+
+		if err != nil {
+			return nil, err
+		}
+
+		fs := args.Effects().FS
+
+		if fs == nil {
+			return sysFail("Can't list directories without a file system"), nil
+		}
+
+		names, err := fs.ReadDir(path)
+
+		if err != nil {
+			return sysFail(err.Error()), nil
+		}
+
+		return sysSuccess(stringList(names)), nil
+	})},
+
+	{"env", NewBuiltin("sys.env", 1, func(args Args) (AST, error) {
+		name, err := args.String(0)
+
+		if err != nil {
+			return nil, err
+		}
+
+		lookup := args.Effects().Env
+
+		if lookup == nil {
+			return sysFail("Can't read environment variables without an environment"), nil
+		}
+
+		value, ok := lookup(name)
+
+		if !ok {
+			return sysFail(fmt.Sprintf("Environment variable %s is not set", name)), nil
+		}
+
+		return sysSuccess(String{Value: value}), nil
+	})},
+}
@@ -0,0 +1,130 @@
+package ast
+
+import "fmt"
+
+// Bodies of fully applied patterns are not evaluated by the pattern, instead
+// they are handed back to the nearest trampoline. Tail recursive patterns then
+// run in constant Go stack space.
+type tailCall struct {
+	body AST
+	env  *Environment
+
+	// The application making the call, empty when applied from Go
+	from tailFrame
+}
+
+// Evaluates tail calls until a value is produced
+func trampoline(res AST, call *tailCall) (AST, error) {
+	var err error
+	var frames *TailFrames
+
+	for call != nil {
+		if call.from.fn != nil {
+			if frames == nil {
+				frames = &TailFrames{}
+			}
+
+			frames.push(call.from)
+		}
+
+		res, call, err = evalTail(call.body, call.env)
+
+		if err != nil {
+			return nil, frames.Wrap(err)
+		}
+	}
+
+	return res, nil
+}
+
+// Evaluates an expression in tail position, applications and lets may return
+// a tail call instead of a value
+func evalTail(a AST, env *Environment) (AST, *tailCall, error) {
+	switch A := a.(type) {
+	case Application:
+		res, call, err := A.evalTail(env)
+
+		if err != nil {
+			return nil, nil, NewRuntimeErrorAt(A.Pos, err, A.describe())
+		}
+
+		return res, call, nil
+
+	case Let:
+		return A.evalTail(env)
+	}
+
+	res, err := a.Eval(env)
+
+	return res, nil, err
+}
+
+// Frames kept at either end of a run of tail calls
+const maxTailFrames = 64
+
+// Applications replaced by their tail calls. They leave nothing on the stack,
+// so they are kept here for stack traces. Only the first and the latest of a
+// long run are kept, tail recursion still runs in constant space.
+type TailFrames struct {
+	first  []tailFrame
+	latest []tailFrame
+
+	// Oldest of the latest frames once they wrap around
+	next    int
+	skipped int
+}
+
+// Frames of the tree walker are described from the applied expression only
+// when an error needs them
+type tailFrame struct {
+	pos         Position
+	fn          AST
+	description string
+}
+
+func (f tailFrame) describe() string {
+	if f.fn != nil {
+		return describeApplied(f.fn)
+	}
+
+	return f.description
+}
+
+func (t *TailFrames) Push(pos Position, description string) {
+	t.push(tailFrame{pos: pos, description: description})
+}
+
+func (t *TailFrames) push(frame tailFrame) {
+	switch {
+	case len(t.first) < maxTailFrames:
+		t.first = append(t.first, frame)
+	case len(t.latest) < maxTailFrames:
+		t.latest = append(t.latest, frame)
+	default:
+		t.latest[t.next] = frame
+		t.next = (t.next + 1) % maxTailFrames
+		t.skipped++
+	}
+}
+
+// Wraps an error raised by the latest tail call with every frame kept
+func (t *TailFrames) Wrap(err error) error {
+	if t == nil {
+		return err
+	}
+
+	for i := len(t.latest) - 1; i >= 0; i-- {
+		frame := t.latest[(t.next+i)%len(t.latest)]
+		err = NewRuntimeErrorAt(frame.pos, err, frame.describe())
+	}
+
+	if t.skipped > 0 {
+		err = NewRuntimeError(err, fmt.Sprintf("... %d more tail calls", t.skipped))
+	}
+
+	for i := len(t.first) - 1; i >= 0; i-- {
+		err = NewRuntimeErrorAt(t.first[i].pos, err, t.first[i].describe())
+	}
+
+	return err
+}
@@ -0,0 +1,50 @@
+package ast
+
+import (
+	"context"
+	"runtime/debug"
+	"testing"
+)
+
+const foldSource = `package fold
+
+foldl = {
+  f z [] -> z
+  f z [m:ms] -> foldl f (f z m) ms
+}
+
+foldl { sum n -> sum + n } 0
+`
+
+// Folding recurses once per element, so without tail calls the fold would
+// need far more stack than it is allowed
+func TestTailCallsRunInConstantStack(t *testing.T) {
+	const size = 100000
+
+	file, err := Parse("fold.sl", []byte(foldSource))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	numbers := List{}
+
+	for i := 1; i <= size; i++ {
+		numbers.Values = append(numbers.Values, Number{Value: i})
+	}
+
+	defer debug.SetMaxStack(debug.SetMaxStack(4 << 20))
+
+	lib := DefaultBuiltins().Let()
+	lib.Body = Application{Body: []AST{file.Definition, numbers}}
+
+	res, err := lib.Eval(NewLimitedEnv(NewBudget(context.Background(), Limits{})))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := (Number{Value: size * (size + 1) / 2}); !res.Equals(expected) {
+		t.Errorf("expected %s, got %s", expected.String(), res.String())
+	}
+}
@@ -0,0 +1,82 @@
+package ast
+
+// A token as the parser reads it, for tools showing how a file is split up.
+// Text is the token as written and Leading the whitespace and comments
+// between it and the token before it.
+type Token struct {
+	Kind    string
+	Value   string
+	Text    string
+	Leading string
+
+	Pos Position
+
+	kind int
+}
+
+// Names of the token kinds, in the order they're declared
+var tokenKindNames = []string{
+	"",
+	"package",
+	"import",
+	"module",
+	"match",
+	"else",
+	"if",
+	"=>",
+	"->",
+	"==",
+	"!=",
+	">=",
+	"<=",
+	"&&",
+	"||",
+	"++",
+	"::",
+	"{", "}",
+	"(", ")",
+	"[", "]",
+	"=",
+	":",
+	",",
+	"+",
+	"-",
+	"*",
+	"/",
+	"%",
+	">",
+	"<",
+	";",
+	"identifier",
+	"label",
+	"string",
+	"number",
+	"decimal",
+	"error",
+	"eof",
+	"comment",
+	"string start",
+	"string middle",
+	"string end",
+}
+
+// Splits a source file into tokens, comments and whitespace are skipped as
+// they are when parsing. The last token is the end of the file.
+func Tokenize(file string, src []byte) ([]Token, error) {
+	p := newParser(file, src)
+	res := []Token{}
+
+	for {
+		t := p.Next()
+
+		if t.kind == TOKEN_KIND_ERROR {
+			return res, NewParseErrorAt(p.pos(t), nil, string(t.value))
+		}
+
+		res = append(res, *p.leaf(t).Token)
+
+		if t.kind == TOKEN_KIND_EOF {
+			return res, nil
+		}
+	}
+}
@@ -9,5 +9,5 @@ var uniqueCounter int = 0
 func NextUniqueId() Identifier {
 	uniqueCounter++
 
-	return Identifier{fmt.Sprintf("`unique_%d`", uniqueCounter)}
+	return Identifier{Value: fmt.Sprintf("`unique_%d`", uniqueCounter)}
 }
@@ -0,0 +1,69 @@
+package check
+
+// Types of the builtins bound by ast.StdLib, along with the logical operators
+func builtinTypes() map[string]Type {
+	newGeneric := func() *typeVar {
+		return &typeVar{level: generic}
+	}
+
+	arithmetic := func(name string) Type {
+		return patternType{name, []arm{
+			{[]Type{numberType{}, numberType{}}, numberType{}},
+		}}
+	}
+
+	comparison := func(name string) Type {
+		return patternType{name, []arm{
+			{[]Type{numberType{}, numberType{}}, newBool()},
+			{[]Type{stringType{}, stringType{}}, newBool()},
+			{[]Type{newLabels(true), newLabels(true)}, newBool()},
+		}}
+	}
+
+	equality := func(name string) Type {
+		return patternType{name, []arm{
+			{[]Type{newGeneric(), newGeneric()}, newBool()},
+		}}
+	}
+
+	logical := func(name string) Type {
+		return patternType{name, []arm{
+			{[]Type{newBool(), newBool()}, newBool()},
+		}}
+	}
+
+	elem := newGeneric()
+	printed := newGeneric()
+	shown := newGeneric()
+
+	res := map[string]Type{
+		"+":  arithmetic("+"),
+		"-":  arithmetic("-"),
+		"*":  arithmetic("*"),
+		"/":  arithmetic("/"),
+		"%":  arithmetic("%"),
+		">":  comparison(">"),
+		">=": comparison(">="),
+		"<":  comparison("<"),
+		"<=": comparison("<="),
+		"==": equality("=="),
+		"!=": equality("!="),
+		"&&": logical("&&"),
+		"||": logical("||"),
+		"++": patternType{"++", []arm{
+			{[]Type{stringType{}, stringType{}}, stringType{}},
+			{[]Type{listType{elem}, listType{elem}}, listType{elem}},
+		}},
+		"print": patternType{"print", []arm{
+			{[]Type{printed}, printed},
+		}},
+		"show": patternType{"show", []arm{
+			{[]Type{shown}, stringType{}},
+		}},
+		"len": patternType{"len", []arm{
+			{[]Type{stringType{}}, numberType{}},
+		}},
+	}
+
+	return res
+}
@@ -0,0 +1,42 @@
+package check
+
+import (
+	"fmt"
+
+	"../ast"
+)
+
+// Type checking is opt in, slang still runs programs the checker rejects.
+// Inference is Hindley-Milner style with a few extensions for how slang
+// programs are written:
+//
+//   - labels are typed by the set of labels a value may be
+//   - list literals keep their length and the type of every element, and
+//     only become homogeneous lists where they are matched as one
+//   - patterns keep all of their arms, applying one considers only the arms
+//     that accept the argument, so records and modules resolve fields
+//   - anything the checker can't follow is dynamic and accepted everywhere
+
+type Error struct {
+	Pos     ast.Position
+	Message string
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("TYPE ERROR: %s %s\n%s", e.Pos, e.Message, ast.SourceDisplay(e.Pos, e.Message))
+}
+
+// Infers the type of an expression with the builtins bound, returning every
+// mismatch found along the way
+func Check(a ast.AST) (Type, []error) {
+	c := &checker{}
+	e := newEnv(nil)
+
+	for name, t := range builtinTypes() {
+		e.bind(name, t)
+	}
+
+	t := c.infer(a, e)
+
+	return t, c.errors
+}
@@ -0,0 +1,235 @@
+package check
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"../slang"
+)
+
+// Sources checked are written below this header, so they start on line 6
+const header = `package t
+
+import "std"
+import "data"
+
+`
+
+// Parses a source along with its imports and checks it
+func checkSource(t *testing.T, src string) []error {
+	path := filepath.Join(t.TempDir(), "t.sl")
+
+	if err := ioutil.WriteFile(path, []byte(header+src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := slang.ParseFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, errs := Check(file.Definition)
+
+	return errs
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+
+		// The first error as line:col and message, empty when accepted
+		err string
+	}{
+		{
+			name: "arithmetic",
+			src:  `1 + 2.5`,
+		},
+		{
+			name: "adding a string",
+			src:  `"a" + 1`,
+			err:  "6:1 + expects number, found string",
+		},
+		{
+			name: "length of a string",
+			src:  `len "abc"`,
+		},
+		{
+			name: "length of a list",
+			src:  `len [1, 2]`,
+			err:  "6:5 len expects string, found [number, number]",
+		},
+		{
+			name: "label union",
+			src: `f = { .a -> 1; .b -> 2 }
+g = { 0 -> .a; _ -> .b }
+
+f (g 1)`,
+		},
+		{
+			name: "label union without a match",
+			src: `f = { .a -> 1; .b -> 2 }
+g = { 0 -> .c; _ -> .d }
+
+f (g 1)`,
+			err: "9:4 No match of f accepts .c | .d, it expects one of .a, .b",
+		},
+		{
+			name: "tuple",
+			src:  `{ [a, b] -> a ++ b } ["x", "y"]`,
+		},
+		{
+			name: "tuple of another length",
+			src:  `{ [a, b] -> a + b } [1, 2, 3]`,
+			err:  "6:21 Pattern expects [number, number], found [number, number, number]",
+		},
+		{
+			name: "list",
+			src:  `{ [] -> 0; [x:xs] -> x } [1, 2, 3]`,
+		},
+		{
+			name: "list of another element",
+			src:  `[1, 2] ++ ["a"]`,
+			err:  "6:11 ++ expects [number..], found [string]",
+		},
+		{
+			name: "record",
+			src: `point = {
+  .x -> 1
+  .y -> 2
+}
+
+point.x + point.y`,
+		},
+		{
+			name: "record without the label",
+			src: `point = {
+  .x -> 1
+  .y -> 2
+}
+
+point.z`,
+			err: "11:6 No match of point accepts .z, it expects one of .x, .y",
+		},
+		{
+			name: "module member",
+			src: `m = module {
+  a = 1
+  b = "s"
+}
+
+m.a + m.b`,
+			err: "11:7 + expects number, found string",
+		},
+		{
+			name: "module without the member",
+			src: `m = module {
+  a = 1
+}
+
+m.c`,
+			err: "10:2 m expects .a, found .c",
+		},
+		{
+			name: "data.record",
+			src: `r = data.record [.x, .y] 1 2
+
+r.x + r.y`,
+		},
+		{
+			name: "polymorphic id",
+			src: `id = { x -> x }
+
+[(id 1) + 1, (id "a") ++ "b", id .c]`,
+		},
+		{
+			name: "polymorphic id applied wrongly",
+			src: `id = { x -> x }
+
+(id "a") + 1`,
+			err: "8:2 + expects number, found string",
+		},
+		{
+			name: "std.map",
+			src:  `(std.map { x -> x ++ "!" } ["a", "b"]) ++ ["c"]`,
+		},
+		{
+			name: "std.map of another element",
+			src:  `std.map { x -> x + 1 } ["a", "b"]`,
+			err:  "6:24 No match of std.map accepts [string, string], it expects one of [], [number..]",
+		},
+		{
+			name: "std.map result",
+			src:  `(std.map { x -> x + 1 } [1, 2]) ++ ["c"]`,
+			err:  "6:36 ++ expects [number..], found [string]",
+		},
+		{
+			name: "std.foldl",
+			src:  `(std.foldl { sum n -> sum + n } 0 [1, 2, 3]) + 1`,
+		},
+		{
+			name: "std.foldl of another element",
+			src:  `std.foldl { sum n -> sum + n } 0 ["a"]`,
+			err:  "6:34 No match of std.foldl accepts [string], it expects one of [], [number..]",
+		},
+	}
+
+	for _, test := range tests {
+		errs := checkSource(t, test.src)
+
+		switch {
+		case test.err == "" && len(errs) > 0:
+			t.Errorf("%s: expected no errors, got %v", test.name, errs[0])
+
+		case test.err != "" && len(errs) == 0:
+			t.Errorf("%s: expected %s, got no errors", test.name, test.err)
+
+		case test.err != "":
+			E, ok := errs[0].(Error)
+
+			if !ok {
+				t.Errorf("%s: expected a type error, got %v", test.name, errs[0])
+				continue
+			}
+
+			if got := fmt.Sprintf("%d:%d %s", E.Pos.Line, E.Pos.Char, E.Message); got != test.err {
+				t.Errorf("%s: expected %s, got %s", test.name, test.err, got)
+			}
+
+			if filepath.Base(E.Pos.File) != "t.sl" {
+				t.Errorf("%s: expected the error in t.sl, got %s", test.name, E.Pos.File)
+			}
+		}
+	}
+}
+
+// Every program in the repo passes the checker
+func TestCheckBootstrapAndLib(t *testing.T) {
+	files, err := filepath.Glob("../bootstrap/*.sl")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lib, err := filepath.Glob("../slang/lib/*.sl")
+
+	if err != nil || len(files) == 0 || len(lib) == 0 {
+		t.Fatalf("no bootstrap or lib files: %v", err)
+	}
+
+	for _, path := range append(files, lib...) {
+		file, err := slang.ParseFile(path)
+
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+
+		if _, errs := Check(file.Definition); len(errs) > 0 {
+			t.Errorf("%s: expected no errors, got %v", path, errs[0])
+		}
+	}
+}
@@ -0,0 +1,723 @@
+package check
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"../ast"
+)
+
+// Lets bind in order, so a value can only see the bindings before it while
+// patterns, applied later, see all of them
+type binding struct {
+	immediate Type
+	deferred  Type
+	depth     int
+}
+
+type env struct {
+	parent *env
+	bound  map[string]binding
+}
+
+func newEnv(parent *env) *env {
+	return &env{parent, map[string]binding{}}
+}
+
+func (e *env) bind(name string, t Type) {
+	e.bound[name] = binding{t, t, 0}
+}
+
+// Looks up a name from a depth of nested patterns
+func (e *env) lookup(name string, depth int) (Type, bool) {
+	for curr := e; curr != nil; curr = curr.parent {
+		if b, ok := curr.bound[name]; ok {
+			if depth > b.depth && b.deferred != nil {
+				return b.deferred, true
+			}
+
+			if b.immediate != nil {
+				return b.immediate, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+type checker struct {
+	nextId int
+	level  int
+
+	// Patterns entered, bodies of patterns run after the lets around them
+	depth int
+
+	trail  []func()
+	errors []error
+}
+
+func (c *checker) newVar() *typeVar {
+	c.nextId++
+
+	return &typeVar{c.nextId, c.level, nil}
+}
+
+func (c *checker) errorf(pos ast.Position, format string, args ...interface{}) {
+	c.errors = append(c.errors, Error{pos, fmt.Sprintf(format, args...)})
+}
+
+// Errors found checking an earlier application keep their own position
+func (c *checker) report(pos ast.Position, err error) {
+	if E, ok := err.(Error); ok {
+		c.errors = append(c.errors, E)
+		return
+	}
+
+	c.errorf(pos, "%s", err)
+}
+
+func position(a ast.AST) ast.Position {
+	switch A := a.(type) {
+	case ast.Application:
+		return A.Pos
+	case ast.Pattern:
+		return A.Pos
+	case ast.Identifier:
+		return A.Pos
+	case ast.Label:
+		return A.Pos
+	case ast.String:
+		return A.Pos
+	case ast.List:
+		return A.Pos
+	case ast.ListConstructor:
+		return A.Pos
+	case ast.Number:
+		return A.Pos
+	case ast.Decimal:
+		return A.Pos
+	case ast.Let:
+		return A.Pos
+	case ast.Where:
+		return A.Pos
+	}
+
+	return ast.Position{}
+}
+
+func (c *checker) infer(a ast.AST, e *env) Type {
+	switch A := a.(type) {
+	case ast.Number, ast.Decimal:
+		return numberType{}
+
+	case ast.String:
+		return stringType{}
+
+	case ast.Label:
+		return newLabels(false, A.Value)
+
+	case ast.Identifier:
+		if t, ok := e.lookup(A.Value, c.depth); ok {
+			return c.instantiate(t)
+		}
+
+		c.errorf(A.Pos, "Unknown identifier '%s'", A.Value)
+
+		return dynType{}
+
+	case ast.List:
+		res := tupleType{[]Type{}}
+
+		for _, v := range A.Values {
+			res.elems = append(res.elems, c.infer(v, e))
+		}
+
+		return res
+
+	case ast.ListConstructor:
+		head := c.infer(A.Head, e)
+		tail := c.infer(A.Tail, e)
+
+		if _, ok := resolve(tail).(stringType); ok {
+			if !overlaps(stringType{}, head) {
+				c.errorf(A.Pos, "List constructor with a string tail must have a string head, found %s", head)
+			}
+
+			return tail
+		}
+
+		elem := c.newVar()
+
+		if err := c.unify(listType{elem}, tail); err != nil {
+			c.errorf(A.Pos, "List constructor tail must be a list or string, found %s", tail)
+
+			return dynType{}
+		}
+
+		return listType{c.join(elem, head)}
+
+	case ast.Application:
+		return c.application(A, e)
+
+	case ast.Pattern:
+		return c.pattern(A, e)
+
+	case ast.Let:
+		return c.let(A, e)
+
+	case ast.Where:
+		c.condition(A.Condition, e)
+
+		return c.infer(A.Match, e)
+	}
+
+	return dynType{}
+}
+
+func (c *checker) condition(cond ast.AST, e *env) {
+	if t := c.infer(cond, e); !overlaps(newBool(), t) {
+		c.errorf(position(cond), "Where condition must be a boolean, found %s", t)
+	}
+}
+
+func (c *checker) application(app ast.Application, e *env) Type {
+	fn := c.infer(app.Body[0], e)
+	name := describe(app.Body[0])
+
+	for _, arg := range app.Body[1:] {
+		pos := position(arg)
+
+		if !pos.IsValid() {
+			pos = app.Pos
+		}
+
+		fn = c.apply(fn, c.infer(arg, e), name, pos)
+
+		// Members are named by the labels accessing them, like std.map
+		if label, ok := arg.(ast.Label); ok {
+			name += "." + label.Value
+		}
+	}
+
+	return fn
+}
+
+// Names the applied value in messages
+func describe(a ast.AST) string {
+	if id, ok := a.(ast.Identifier); ok {
+		return id.Value
+	}
+
+	return "Pattern"
+}
+
+func (c *checker) apply(fn Type, arg Type, name string, pos ast.Position) Type {
+	switch F := resolve(fn).(type) {
+	case dynType:
+		return F
+
+	// Applying an unknown value makes it a pattern
+	case *typeVar:
+		res := c.newVar()
+		c.bindVar(F, &openPattern{[]use{{arg, res, name, pos}}, nil})
+
+		return res
+
+	case *openPattern:
+		res := c.newVar()
+		uses := F.uses
+		F.uses = append(append([]use{}, uses...), use{arg, res, name, pos})
+		c.record(func() { F.uses = uses })
+
+		return res
+
+	case patternType:
+		return c.applyPattern(F, arg, name, pos)
+
+	case unionType:
+		var res Type
+
+		for _, alt := range F.alts {
+			if !isPattern(alt) {
+				continue
+			}
+
+			t := c.apply(alt, arg, name, pos)
+
+			if res == nil {
+				res = t
+			} else {
+				res = c.join(res, t)
+			}
+		}
+
+		if res != nil {
+			return res
+		}
+	}
+
+	c.errorf(pos, "Cannot apply a value to %s", fn)
+
+	return dynType{}
+}
+
+func (c *checker) applyPattern(p patternType, arg Type, name string, pos ast.Position) Type {
+	res, err := c.applyArms(p, arg, name)
+
+	if err != nil {
+		c.report(pos, err)
+
+		return dynType{}
+	}
+
+	return res
+}
+
+// Applies an argument to the arms that may accept it
+func (c *checker) applyArms(p patternType, arg Type, name string) (Type, error) {
+	candidates := []arm{}
+	exact := []arm{}
+
+	for _, a := range p.arms {
+		if overlaps(a.matches[0], arg) {
+			candidates = append(candidates, a)
+
+			if sameShape(a.matches[0], arg) {
+				exact = append(exact, a)
+			}
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil, errors.New(c.noMatch(p, arg, name))
+	}
+
+	// Strings match list arms too, but arms for strings are preferred
+	if len(exact) > 0 {
+		candidates = exact
+	}
+
+	// An unknown argument takes the shape of the only arm that accepts it,
+	// or the labels of the arms when they all match labels
+	if v, ok := resolve(arg).(*typeVar); ok {
+		if len(candidates) == 1 {
+			c.unify(v, candidates[0].matches[0])
+		} else if labels, ok := armLabels(candidates); ok {
+			c.unify(v, labels)
+		}
+	}
+
+	for _, a := range candidates {
+		if err := c.refine(a.matches[0], arg); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.arity() > 1 {
+		res := patternType{p.name, []arm{}}
+
+		for _, a := range candidates {
+			res.arms = append(res.arms, arm{a.matches[1:], a.body})
+		}
+
+		return res, nil
+	}
+
+	res := candidates[0].body
+
+	// Which arm an unknown argument takes isn't known, so results not known
+	// yet either are kept apart rather than unified. A pattern passed along
+	// as the state of a recursion, as data.record builds records, would
+	// otherwise return the same type from every arm.
+	for _, a := range candidates[1:] {
+		if isVar(arg) && isVar(res) && isVar(a.body) {
+			res = unionType{append(alternatives(res), a.body)}
+		} else {
+			res = c.join(res, a.body)
+		}
+	}
+
+	return res, nil
+}
+
+func armLabels(arms []arm) (*labelType, bool) {
+	labels := []*labelType{}
+
+	for _, a := range arms {
+		L, ok := resolve(a.matches[0]).(*labelType)
+
+		if !ok || L.grows {
+			return nil, false
+		}
+
+		labels = append(labels, L)
+	}
+
+	return grownLabels(false, labels...), true
+}
+
+// Whether a match accepts the argument without treating strings as lists,
+// matches accepting anything accept every shape
+func sameShape(match Type, arg Type) bool {
+	switch resolve(arg).(type) {
+	case *typeVar, dynType, unionType:
+		return true
+	}
+
+	switch resolve(match).(type) {
+	case *typeVar, dynType:
+		return true
+
+	case stringType:
+		_, ok := resolve(arg).(stringType)
+		return ok
+
+	case listType, tupleType:
+		switch resolve(arg).(type) {
+		case listType, tupleType:
+			return true
+		}
+
+		return false
+	}
+
+	return true
+}
+
+func (c *checker) noMatch(p patternType, arg Type, name string) string {
+	if p.name != "" {
+		name = p.name
+	}
+
+	if len(p.arms) == 1 {
+		return fmt.Sprintf("%s expects %s, found %s", name, p.arms[0].matches[0], arg)
+	}
+
+	matches := []string{}
+
+	for _, a := range p.arms {
+		matches = append(matches, a.matches[0].String())
+	}
+
+	return fmt.Sprintf("No match of %s accepts %s, it expects one of %s", name, arg, strings.Join(matches, ", "))
+}
+
+func (c *checker) pattern(pattern ast.Pattern, e *env) Type {
+	c.depth++
+	defer func() { c.depth-- }()
+
+	res := patternType{"", []arm{}}
+
+	for i, matchGroup := range pattern.Matches {
+		armEnv := newEnv(e)
+		a := arm{[]Type{}, nil}
+
+		for _, m := range matchGroup {
+			a.matches = append(a.matches, c.match(m, armEnv))
+		}
+
+		a.body = c.infer(pattern.Bodies[i], armEnv)
+		res.arms = append(res.arms, a)
+	}
+
+	return res
+}
+
+// Types a match, binding its identifiers in the arm's environment. As when
+// running, identifiers that are already bound test for equality.
+func (c *checker) match(m ast.AST, armEnv *env) Type {
+	switch M := m.(type) {
+	case ast.Identifier:
+		if M.Value == "_" {
+			return c.newVar()
+		}
+
+		if b, ok := armEnv.bound[M.Value]; ok {
+			return b.immediate
+		}
+
+		if t, ok := armEnv.lookup(M.Value, c.depth); ok {
+			return c.instantiate(t)
+		}
+
+		v := c.newVar()
+		armEnv.bind(M.Value, v)
+
+		return v
+
+	case ast.List:
+		res := tupleType{[]Type{}}
+
+		for _, v := range M.Values {
+			res.elems = append(res.elems, c.match(v, armEnv))
+		}
+
+		return res
+
+	case ast.ListConstructor:
+		res := listType{c.match(M.Head, armEnv)}
+		c.tryUnify(c.match(M.Tail, armEnv), res)
+
+		return res
+
+	case ast.Where:
+		res := c.match(M.Match, armEnv)
+		c.condition(M.Condition, armEnv)
+
+		return res
+	}
+
+	return c.infer(m, armEnv)
+}
+
+// Bindings are checked in groups that refer to each other, each group
+// generalized before the bindings that depend on it are checked
+func (c *checker) let(let ast.Let, e *env) Type {
+	n := len(let.BoundIds)
+	deps := letDependencies(let)
+	types := make([]Type, n)
+	inner := newEnv(e)
+
+	for _, group := range components(deps) {
+		sort.Ints(group)
+
+		c.level++
+		placeholders := map[int]Type{}
+		values := map[int]Type{}
+
+		for _, i := range group {
+			placeholders[i] = c.newVar()
+		}
+
+		for _, i := range group {
+			c.letScope(inner, let, i, types, placeholders)
+			values[i] = c.infer(let.BoundValues[i], inner)
+
+			if err := c.unify(placeholders[i], values[i]); err != nil {
+				c.report(let.BoundIds[i].Pos, err)
+			}
+		}
+
+		c.level--
+
+		// The value is more precise than the placeholder, which may only
+		// know how the binding was applied
+		for _, i := range group {
+			generalize(values[i], c.level)
+			types[i] = values[i]
+		}
+	}
+
+	c.letScope(inner, let, n, types, nil)
+
+	return c.infer(let.Body, inner)
+}
+
+// Binds the names of a let as seen from its i-th value
+func (c *checker) letScope(inner *env, let ast.Let, i int, types []Type, placeholders map[int]Type) {
+	inner.bound = map[string]binding{}
+
+	typeOf := func(j int) Type {
+		if t, ok := placeholders[j]; ok {
+			return t
+		}
+
+		return types[j]
+	}
+
+	for j, id := range let.BoundIds {
+		b := inner.bound[id.Value]
+		b.depth = c.depth
+
+		if t := typeOf(j); t != nil {
+			b.deferred = t
+
+			if j < i {
+				b.immediate = t
+			}
+		}
+
+		inner.bound[id.Value] = b
+	}
+}
+
+// Which bindings each value refers to. Values see earlier bindings, and
+// patterns see every binding since they are applied after the let.
+func letDependencies(let ast.Let) [][]int {
+	names := map[string]bool{}
+
+	for _, id := range let.BoundIds {
+		names[id.Value] = true
+	}
+
+	deps := make([][]int, len(let.BoundIds))
+
+	for i, value := range let.BoundValues {
+		refs(value, names, map[string]bool{}, false, func(name string, deferred bool) {
+			last := -1
+
+			for j, id := range let.BoundIds {
+				if id.Value == name && (deferred || j < i) {
+					last = j
+				}
+			}
+
+			if last >= 0 {
+				deps[i] = append(deps[i], last)
+			}
+		})
+	}
+
+	return deps
+}
+
+// Visits the references to names that aren't shadowed by locals
+func refs(a ast.AST, names map[string]bool, locals map[string]bool, deferred bool, visit func(string, bool)) {
+	switch A := a.(type) {
+	case ast.Identifier:
+		if names[A.Value] && !locals[A.Value] {
+			visit(A.Value, deferred)
+		}
+
+	case ast.Application:
+		for _, v := range A.Body {
+			refs(v, names, locals, deferred, visit)
+		}
+
+	case ast.List:
+		for _, v := range A.Values {
+			refs(v, names, locals, deferred, visit)
+		}
+
+	case ast.ListConstructor:
+		refs(A.Head, names, locals, deferred, visit)
+		refs(A.Tail, names, locals, deferred, visit)
+
+	case ast.Where:
+		refs(A.Condition, names, locals, deferred, visit)
+		refs(A.Match, names, locals, deferred, visit)
+
+	case ast.Pattern:
+		for i, matchGroup := range A.Matches {
+			armLocals := copyNames(locals)
+
+			for _, m := range matchGroup {
+				matchRefs(m, names, armLocals, visit)
+			}
+
+			refs(A.Bodies[i], names, armLocals, true, visit)
+		}
+
+	case ast.Let:
+		inner := copyNames(locals)
+
+		for _, id := range A.BoundIds {
+			inner[id.Value] = true
+		}
+
+		for _, v := range A.BoundValues {
+			refs(v, names, inner, deferred, visit)
+		}
+
+		refs(A.Body, names, inner, deferred, visit)
+	}
+}
+
+func matchRefs(m ast.AST, names map[string]bool, locals map[string]bool, visit func(string, bool)) {
+	switch M := m.(type) {
+	case ast.Identifier:
+		switch {
+		case M.Value == "_" || locals[M.Value]:
+		case names[M.Value]:
+			visit(M.Value, true)
+		default:
+			locals[M.Value] = true
+		}
+
+	case ast.List:
+		for _, v := range M.Values {
+			matchRefs(v, names, locals, visit)
+		}
+
+	case ast.ListConstructor:
+		matchRefs(M.Head, names, locals, visit)
+		matchRefs(M.Tail, names, locals, visit)
+
+	case ast.Where:
+		matchRefs(M.Match, names, locals, visit)
+		refs(M.Condition, names, locals, true, visit)
+
+	default:
+		refs(m, names, locals, true, visit)
+	}
+}
+
+func copyNames(names map[string]bool) map[string]bool {
+	res := map[string]bool{}
+
+	for k, v := range names {
+		res[k] = v
+	}
+
+	return res
+}
+
+// Strongly connected components of the dependency graph, each one after the
+// components it depends on
+func components(deps [][]int) [][]int {
+	index := make([]int, len(deps))
+	low := make([]int, len(deps))
+	onStack := make([]bool, len(deps))
+	stack := []int{}
+	res := [][]int{}
+	next := 1
+
+	var visit func(int)
+
+	visit = func(v int) {
+		index[v] = next
+		low[v] = next
+		next++
+		stack = append(stack, v)
+		onStack[v] = true
+
+		for _, w := range deps[v] {
+			if index[w] == 0 {
+				visit(w)
+
+				if low[w] < low[v] {
+					low[v] = low[w]
+				}
+			} else if onStack[w] && index[w] < low[v] {
+				low[v] = index[w]
+			}
+		}
+
+		if low[v] == index[v] {
+			component := []int{}
+
+			for {
+				w := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[w] = false
+				component = append(component, w)
+
+				if w == v {
+					break
+				}
+			}
+
+			res = append(res, component)
+		}
+	}
+
+	for v := range deps {
+		if index[v] == 0 {
+			visit(v)
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,432 @@
+package check
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"../ast"
+)
+
+type Type interface {
+	String() string
+}
+
+// Level of variables that are instantiated afresh on every use
+const generic = math.MaxInt32
+
+// Deeper types are elided when printed
+const maxPrintDepth = 4
+
+type typeVar struct {
+	id    int
+	level int
+	bound Type
+}
+
+// Anything the checker can't reason about, it is compatible with every type
+type dynType struct{}
+
+// Integers and decimals promote to each other, so both are numbers
+type numberType struct{}
+
+type stringType struct{}
+
+// The labels a value may be. Unifying label types merges them into one
+// forwarded set so every alias sees the union. Labels a parameter was
+// applied to grow as it is applied to more, rather than rejecting them.
+type labelType struct {
+	labels  map[string]bool
+	any     bool
+	grows   bool
+	forward *labelType
+}
+
+// Homogeneous lists of any length
+type listType struct {
+	elem Type
+}
+
+// List literals and list matches of a known length
+type tupleType struct {
+	elems []Type
+}
+
+// Alternatives of different shapes, like the branches of a pattern returning
+// either [.none] or [.some, v]
+type unionType struct {
+	alts []Type
+}
+
+type arm struct {
+	matches []Type
+	body    Type
+}
+
+// Patterns keep every arm, so applying one only considers the arms whose
+// matches accept the argument. Records and modules are patterns from labels
+// to values.
+type patternType struct {
+	name string
+	arms []arm
+}
+
+// A value only known from how it was applied. Every application is kept and
+// checked against the pattern the value turns out to be.
+type openPattern struct {
+	uses    []use
+	forward Type
+}
+
+type use struct {
+	arg    Type
+	result Type
+	name   string
+	pos    ast.Position
+}
+
+// Copies the labels of each label type into a new one
+func grownLabels(grows bool, types ...*labelType) *labelType {
+	res := newLabels(false)
+	res.grows = grows
+
+	for _, t := range types {
+		res.any = res.any || t.any
+		res.grows = res.grows || t.grows
+
+		for l := range t.labels {
+			res.labels[l] = true
+		}
+	}
+
+	return res
+}
+
+// Label types are merged in place, so every boolean needs its own
+func newBool() *labelType {
+	return newLabels(false, "true", "false")
+}
+
+func newLabels(any bool, labels ...string) *labelType {
+	res := &labelType{map[string]bool{}, any, false, nil}
+
+	for _, l := range labels {
+		res.labels[l] = true
+	}
+
+	return res
+}
+
+func resolve(t Type) Type {
+	for {
+		switch T := t.(type) {
+		case *typeVar:
+			if T.bound == nil {
+				return T
+			}
+
+			t = T.bound
+
+		case *labelType:
+			if T.forward == nil {
+				return T
+			}
+
+			t = T.forward
+
+		case *openPattern:
+			if T.forward == nil {
+				return T
+			}
+
+			t = T.forward
+
+		default:
+			return t
+		}
+	}
+}
+
+func isVar(t Type) bool {
+	_, ok := resolve(t).(*typeVar)
+
+	return ok
+}
+
+func (p patternType) arity() int {
+	if len(p.arms) == 0 {
+		return 0
+	}
+
+	return len(p.arms[0].matches)
+}
+
+// -- Strings ---------------------------
+
+func (t *typeVar) String() string     { return typeString(t, 0) }
+func (t dynType) String() string      { return "dyn" }
+func (t numberType) String() string   { return "number" }
+func (t stringType) String() string   { return "string" }
+func (t *labelType) String() string   { return typeString(t, 0) }
+func (t listType) String() string     { return typeString(t, 0) }
+func (t tupleType) String() string    { return typeString(t, 0) }
+func (t unionType) String() string    { return typeString(t, 0) }
+func (t *openPattern) String() string { return typeString(t, 0) }
+func (t patternType) String() string {
+	if t.name != "" {
+		return t.name
+	}
+
+	return typeString(t, 0)
+}
+
+func varName(id int) string {
+	name := string(rune('a' + id%26))
+
+	if id >= 26 {
+		name += fmt.Sprintf("%d", id/26)
+	}
+
+	return "'" + name
+}
+
+func typeString(t Type, depth int) string {
+	t = resolve(t)
+
+	if depth > maxPrintDepth {
+		return "..."
+	}
+
+	switch T := t.(type) {
+	case *typeVar:
+		return varName(T.id)
+
+	case *labelType:
+		if T.any {
+			return "label"
+		}
+
+		labels := []string{}
+
+		for l := range T.labels {
+			labels = append(labels, "."+l)
+		}
+
+		sort.Strings(labels)
+
+		return strings.Join(labels, " | ")
+
+	case listType:
+		return "[" + typeString(T.elem, depth+1) + "..]"
+
+	case tupleType:
+		elems := []string{}
+
+		for _, e := range T.elems {
+			elems = append(elems, typeString(e, depth+1))
+		}
+
+		return "[" + strings.Join(elems, ", ") + "]"
+
+	case unionType:
+		alts := []string{}
+
+		for _, a := range T.alts {
+			alts = append(alts, typeString(a, depth+1))
+		}
+
+		return strings.Join(alts, " | ")
+
+	case patternType:
+		arms := []string{}
+
+		for _, a := range T.arms {
+			matches := []string{}
+
+			for _, m := range a.matches {
+				str := typeString(m, depth+1)
+
+				if strings.Contains(str, " ") {
+					str = "(" + str + ")"
+				}
+
+				matches = append(matches, str)
+			}
+
+			arms = append(arms, strings.Join(matches, " ")+" -> "+typeString(a.body, depth+1))
+		}
+
+		return "{ " + strings.Join(arms, "; ") + " }"
+
+	case *openPattern:
+		uses := []string{}
+
+		for _, u := range T.uses {
+			uses = append(uses, typeString(u.arg, depth+1)+" -> "+typeString(u.result, depth+1))
+		}
+
+		return "{ " + strings.Join(uses, "; ") + " }"
+	}
+
+	return t.String()
+}
+
+// -- Generalization --------------------
+
+func generalize(t Type, level int) {
+	switch T := resolve(t).(type) {
+	case *typeVar:
+		if T.level > level {
+			T.level = generic
+		}
+
+	case listType:
+		generalize(T.elem, level)
+
+	case tupleType:
+		for _, e := range T.elems {
+			generalize(e, level)
+		}
+
+	case unionType:
+		for _, a := range T.alts {
+			generalize(a, level)
+		}
+
+	case patternType:
+		for _, a := range T.arms {
+			for _, m := range a.matches {
+				generalize(m, level)
+			}
+
+			generalize(a.body, level)
+		}
+
+	case *openPattern:
+		for _, u := range T.uses {
+			generalize(u.arg, level)
+			generalize(u.result, level)
+		}
+	}
+}
+
+// Copies a type with fresh variables in place of generic ones. Label types
+// are copied too, since unifying merges them in place.
+func (c *checker) instantiate(t Type) Type {
+	return c.copyType(t, map[*typeVar]Type{})
+}
+
+func (c *checker) copyType(t Type, vars map[*typeVar]Type) Type {
+	switch T := resolve(t).(type) {
+	case *typeVar:
+		if T.level != generic {
+			return T
+		}
+
+		if v, ok := vars[T]; ok {
+			return v
+		}
+
+		vars[T] = c.newVar()
+
+		return vars[T]
+
+	case *labelType:
+		return grownLabels(T.grows, T)
+
+	case listType:
+		return listType{c.copyType(T.elem, vars)}
+
+	case tupleType:
+		res := tupleType{[]Type{}}
+
+		for _, e := range T.elems {
+			res.elems = append(res.elems, c.copyType(e, vars))
+		}
+
+		return res
+
+	case unionType:
+		res := unionType{[]Type{}}
+
+		for _, a := range T.alts {
+			res.alts = append(res.alts, c.copyType(a, vars))
+		}
+
+		return res
+
+	case patternType:
+		res := patternType{T.name, []arm{}}
+
+		for _, a := range T.arms {
+			copied := arm{[]Type{}, c.copyType(a.body, vars)}
+
+			for _, m := range a.matches {
+				copied.matches = append(copied.matches, c.copyType(m, vars))
+			}
+
+			res.arms = append(res.arms, copied)
+		}
+
+		return res
+
+	case *openPattern:
+		res := &openPattern{[]use{}, nil}
+
+		for _, u := range T.uses {
+			res.uses = append(res.uses, use{c.copyType(u.arg, vars), c.copyType(u.result, vars), u.name, u.pos})
+		}
+
+		return res
+
+	default:
+		return T
+	}
+}
+
+func occurs(v *typeVar, t Type) bool {
+	switch T := resolve(t).(type) {
+	case *typeVar:
+		return T == v
+
+	case listType:
+		return occurs(v, T.elem)
+
+	case tupleType:
+		for _, e := range T.elems {
+			if occurs(v, e) {
+				return true
+			}
+		}
+
+	case unionType:
+		for _, a := range T.alts {
+			if occurs(v, a) {
+				return true
+			}
+		}
+
+	case patternType:
+		for _, a := range T.arms {
+			for _, m := range a.matches {
+				if occurs(v, m) {
+					return true
+				}
+			}
+
+			if occurs(v, a.body) {
+				return true
+			}
+		}
+
+	case *openPattern:
+		for _, u := range T.uses {
+			if occurs(v, u.arg) || occurs(v, u.result) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,696 @@
+package check
+
+import (
+	"fmt"
+)
+
+type mismatch struct {
+	expected Type
+	found    Type
+}
+
+func (m mismatch) Error() string {
+	return fmt.Sprintf("Expected %s, found %s", m.expected, m.found)
+}
+
+// Records how to undo a binding, so failed attempts at unifying can be
+// rolled back
+func (c *checker) record(undo func()) {
+	c.trail = append(c.trail, undo)
+}
+
+func (c *checker) rollback(mark int) {
+	for i := len(c.trail) - 1; i >= mark; i-- {
+		c.trail[i]()
+	}
+
+	c.trail = c.trail[:mark]
+}
+
+func (c *checker) tryUnify(a Type, b Type) bool {
+	mark := len(c.trail)
+
+	if err := c.unify(a, b); err != nil {
+		c.rollback(mark)
+
+		return false
+	}
+
+	return true
+}
+
+func (c *checker) bindVar(v *typeVar, t Type) {
+	// Recursive types, like a pattern returning itself partially applied,
+	// can't be written down so they are left dynamic
+	if occurs(v, t) {
+		t = dynType{}
+	}
+
+	c.adjustLevels(t, v.level)
+	v.bound = t
+	c.record(func() { v.bound = nil })
+}
+
+// Variables bound into a type from an outer level can't be generalized
+// at the inner one
+func (c *checker) adjustLevels(t Type, level int) {
+	switch T := resolve(t).(type) {
+	case *typeVar:
+		if T.level > level {
+			old := T.level
+			T.level = level
+			c.record(func() { T.level = old })
+		}
+
+	case listType:
+		c.adjustLevels(T.elem, level)
+
+	case tupleType:
+		for _, e := range T.elems {
+			c.adjustLevels(e, level)
+		}
+
+	case unionType:
+		for _, a := range T.alts {
+			c.adjustLevels(a, level)
+		}
+
+	case patternType:
+		for _, a := range T.arms {
+			for _, m := range a.matches {
+				c.adjustLevels(m, level)
+			}
+
+			c.adjustLevels(a.body, level)
+		}
+
+	case *openPattern:
+		for _, u := range T.uses {
+			c.adjustLevels(u.arg, level)
+			c.adjustLevels(u.result, level)
+		}
+	}
+}
+
+func (c *checker) mergeLabels(a *labelType, b *labelType) {
+	if a == b {
+		return
+	}
+
+	res := grownLabels(false, a, b)
+	a.forward = res
+	b.forward = res
+	c.record(func() {
+		a.forward = nil
+		b.forward = nil
+	})
+}
+
+func (c *checker) unify(a Type, b Type) error {
+	a = resolve(a)
+	b = resolve(b)
+
+	if A, ok := a.(*typeVar); ok {
+		if B, ok := b.(*typeVar); ok && A == B {
+			return nil
+		}
+
+		c.bindVar(A, b)
+
+		return nil
+	}
+
+	if B, ok := b.(*typeVar); ok {
+		c.bindVar(B, a)
+
+		return nil
+	}
+
+	_, aDyn := a.(dynType)
+	_, bDyn := b.(dynType)
+
+	if aDyn || bDyn {
+		return nil
+	}
+
+	if A, ok := a.(unionType); ok {
+		return c.unifyUnion(A, b, a, b)
+	}
+
+	if B, ok := b.(unionType); ok {
+		return c.unifyUnion(B, a, a, b)
+	}
+
+	switch A := a.(type) {
+	case numberType:
+		if _, ok := b.(numberType); ok {
+			return nil
+		}
+
+	case stringType:
+		switch B := b.(type) {
+		case stringType:
+			return nil
+
+		// Strings are lists of single character strings
+		case listType:
+			return c.unify(B.elem, a)
+
+		case tupleType:
+			for _, e := range B.elems {
+				if err := c.unify(e, a); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+
+	case *labelType:
+		if B, ok := b.(*labelType); ok {
+			c.mergeLabels(A, B)
+
+			return nil
+		}
+
+	case listType:
+		switch B := b.(type) {
+		case stringType:
+			return c.unify(A.elem, b)
+
+		case listType:
+			return c.unify(A.elem, B.elem)
+
+		case tupleType:
+			c.unifyElems(A, B)
+
+			return nil
+		}
+
+	case tupleType:
+		switch B := b.(type) {
+		case stringType, listType:
+			return c.unify(b, a)
+
+		case tupleType:
+			if len(A.elems) != len(B.elems) {
+				break
+			}
+
+			for i := range A.elems {
+				if err := c.unify(A.elems[i], B.elems[i]); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+
+	case patternType:
+		switch B := b.(type) {
+		case patternType:
+			return c.unifyPatterns(A, B)
+		case *openPattern:
+			return c.unify(b, a)
+		}
+
+	case *openPattern:
+		switch B := b.(type) {
+		case patternType:
+			return c.checkUses(A, B)
+
+		case *openPattern:
+			merged := &openPattern{append(append([]use{}, A.uses...), B.uses...), nil}
+			A.forward = merged
+			B.forward = merged
+			c.record(func() {
+				A.forward = nil
+				B.forward = nil
+			})
+
+			return nil
+		}
+	}
+
+	return mismatch{a, b}
+}
+
+// Checks every application of a value against the pattern it turned out to be
+func (c *checker) checkUses(open *openPattern, p patternType) error {
+	open.forward = p
+	c.record(func() { open.forward = nil })
+
+	for _, u := range open.uses {
+		res, err := c.applyArms(p, u.arg, u.name)
+
+		if err == nil {
+			err = c.unify(u.result, res)
+		}
+
+		if err != nil {
+			return Error{u.pos, err.Error()}
+		}
+	}
+
+	return nil
+}
+
+// Literals aren't always homogeneous, elements that don't fit the list are
+// left unchecked
+func (c *checker) unifyElems(list listType, tuple tupleType) {
+	for _, e := range tuple.elems {
+		c.tryUnify(list.elem, e)
+	}
+}
+
+// A union unifies with the only alternative that fits, when several fit it
+// isn't known which one will be used
+func (c *checker) unifyUnion(u unionType, t Type, a Type, b Type) error {
+	fits := []Type{}
+
+	for _, alt := range u.alts {
+		if overlaps(alt, t) {
+			fits = append(fits, alt)
+		}
+	}
+
+	switch len(fits) {
+	case 0:
+		return mismatch{a, b}
+	case 1:
+		return c.unify(fits[0], t)
+	}
+
+	return nil
+}
+
+// Splits off the first column of every arm, so patterns taking a different
+// number of arguments can be compared
+func curry(p patternType) patternType {
+	if p.arity() <= 1 {
+		return p
+	}
+
+	res := patternType{"", []arm{}}
+
+	for _, a := range p.arms {
+		rest := patternType{"", []arm{{a.matches[1:], a.body}}}
+		res.arms = append(res.arms, arm{a.matches[:1], rest})
+	}
+
+	return res
+}
+
+func (c *checker) unifyPatterns(a patternType, b patternType) error {
+	if a.arity() != b.arity() {
+		a = curry(a)
+		b = curry(b)
+	}
+
+	if a.arity() != b.arity() {
+		return mismatch{a, b}
+	}
+
+	// A pattern only known from how it was applied has a single arm that
+	// stands in for all of the other pattern's arms
+	if len(b.arms) == 1 && len(a.arms) > 1 {
+		a, b = b, a
+	}
+
+	if len(a.arms) == 1 {
+		var body Type
+
+		for _, other := range b.arms {
+			for i := range other.matches {
+				c.tryUnify(a.arms[0].matches[i], other.matches[i])
+			}
+
+			if body == nil {
+				body = other.body
+			} else {
+				body = c.join(body, other.body)
+			}
+		}
+
+		return c.unify(a.arms[0].body, body)
+	}
+
+	if len(a.arms) == len(b.arms) {
+		for i := range a.arms {
+			for j := range a.arms[i].matches {
+				c.tryUnify(a.arms[i].matches[j], b.arms[i].matches[j])
+			}
+
+			c.tryUnify(a.arms[i].body, b.arms[i].body)
+		}
+	}
+
+	return nil
+}
+
+// Combines the types of values that may flow to the same place, like the
+// bodies of a pattern. Unlike unify it never fails, types of different shapes
+// become a union.
+func (c *checker) join(a Type, b Type) Type {
+	a = resolve(a)
+	b = resolve(b)
+
+	if _, ok := a.(*typeVar); ok {
+		c.unify(a, b)
+
+		return a
+	}
+
+	if _, ok := b.(*typeVar); ok {
+		c.unify(b, a)
+
+		return b
+	}
+
+	if _, ok := a.(dynType); ok {
+		return a
+	}
+
+	if _, ok := b.(dynType); ok {
+		return b
+	}
+
+	alts := []Type{}
+
+	for _, t := range append(alternatives(a), alternatives(b)...) {
+		merged := false
+
+		for i, alt := range alts {
+			if res, ok := c.joinShape(alt, t); ok {
+				alts[i] = res
+				merged = true
+
+				break
+			}
+		}
+
+		if !merged {
+			alts = append(alts, t)
+		}
+	}
+
+	if len(alts) == 1 {
+		return alts[0]
+	}
+
+	return unionType{alts}
+}
+
+func alternatives(t Type) []Type {
+	if U, ok := t.(unionType); ok {
+		return U.alts
+	}
+
+	return []Type{t}
+}
+
+// Joins two types of the same shape
+func (c *checker) joinShape(a Type, b Type) (Type, bool) {
+	a = resolve(a)
+	b = resolve(b)
+
+	if _, ok := b.(*typeVar); ok {
+		return c.join(a, b), true
+	}
+
+	switch A := a.(type) {
+	case *typeVar, dynType:
+		return c.join(a, b), true
+
+	case numberType:
+		if _, ok := b.(numberType); ok {
+			return a, true
+		}
+
+	case stringType:
+		if _, ok := b.(stringType); ok {
+			return a, true
+		}
+
+	case *labelType:
+		if B, ok := b.(*labelType); ok {
+			return grownLabels(false, A, B), true
+		}
+
+	case listType:
+		switch B := b.(type) {
+		case listType:
+			return listType{c.join(A.elem, B.elem)}, true
+
+		case tupleType:
+			if c.tryUnify(a, b) {
+				return a, true
+			}
+		}
+
+	case tupleType:
+		switch B := b.(type) {
+		case listType:
+			return c.joinShape(b, a)
+
+		case tupleType:
+			if len(A.elems) == len(B.elems) {
+				res := tupleType{[]Type{}}
+
+				for i := range A.elems {
+					res.elems = append(res.elems, c.join(A.elems[i], B.elems[i]))
+				}
+
+				return res, true
+			}
+		}
+
+	case patternType, *openPattern:
+		if isPattern(b) && c.tryUnify(a, b) {
+			return resolve(a), true
+		}
+	}
+
+	return nil, false
+}
+
+// Whether a value of the argument's type could be accepted by a match of
+// the given type
+func overlaps(match Type, arg Type) bool {
+	match = resolve(match)
+	arg = resolve(arg)
+
+	switch arg.(type) {
+	case *typeVar, dynType:
+		return true
+
+	case unionType:
+		for _, alt := range arg.(unionType).alts {
+			if overlaps(match, alt) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	switch M := match.(type) {
+	case *typeVar, dynType:
+		return true
+
+	case unionType:
+		for _, alt := range M.alts {
+			if overlaps(alt, arg) {
+				return true
+			}
+		}
+
+	case numberType:
+		_, ok := arg.(numberType)
+		return ok
+
+	case stringType:
+		switch A := arg.(type) {
+		case stringType:
+			return true
+		case listType:
+			return overlaps(A.elem, match)
+		case tupleType:
+			return overlaps(A, match)
+		}
+
+	case *labelType:
+		if A, ok := arg.(*labelType); ok {
+			if M.any || M.grows || A.any {
+				return true
+			}
+
+			for l := range M.labels {
+				if A.labels[l] {
+					return true
+				}
+			}
+		}
+
+	case listType:
+		switch A := arg.(type) {
+		case stringType:
+			return overlaps(M.elem, A)
+		case listType:
+			return true
+		case tupleType:
+			for _, e := range A.elems {
+				if !overlaps(M.elem, e) {
+					return false
+				}
+			}
+
+			return true
+		}
+
+	case tupleType:
+		switch A := arg.(type) {
+		case stringType:
+			for _, e := range M.elems {
+				if !overlaps(e, A) {
+					return false
+				}
+			}
+
+			return true
+
+		case listType:
+			for _, e := range M.elems {
+				if !overlaps(e, A.elem) {
+					return false
+				}
+			}
+
+			return true
+
+		case tupleType:
+			if len(M.elems) != len(A.elems) {
+				return false
+			}
+
+			for i := range M.elems {
+				if !overlaps(M.elems[i], A.elems[i]) {
+					return false
+				}
+			}
+
+			return true
+		}
+
+	case patternType, *openPattern:
+		return isPattern(arg)
+	}
+
+	return false
+}
+
+func isPattern(t Type) bool {
+	switch resolve(t).(type) {
+	case patternType, *openPattern:
+		return true
+	}
+
+	return false
+}
+
+// Binds the variables of a match to the parts of the argument they match
+func (c *checker) refine(match Type, arg Type) error {
+	match = resolve(match)
+	arg = resolve(arg)
+
+	// Parameters bound to labels keep growing as they are applied to more
+	if A, ok := arg.(*labelType); ok {
+		switch M := match.(type) {
+		case *typeVar:
+			c.bindVar(M, grownLabels(true, A))
+			return nil
+
+		case *labelType:
+			if M.grows {
+				res := grownLabels(true, M, A)
+				M.forward = res
+				c.record(func() { M.forward = nil })
+			}
+
+			return nil
+		}
+	}
+
+	if M, ok := match.(*typeVar); ok {
+		return c.unify(M, arg)
+	}
+
+	if U, ok := arg.(unionType); ok {
+		fits := []Type{}
+
+		for _, alt := range U.alts {
+			if overlaps(match, alt) {
+				fits = append(fits, alt)
+			}
+		}
+
+		if len(fits) == 1 {
+			return c.refine(match, fits[0])
+		}
+
+		return nil
+	}
+
+	switch M := match.(type) {
+	case listType:
+		switch A := arg.(type) {
+		case stringType:
+			return c.refine(M.elem, A)
+		case listType:
+			return c.refine(M.elem, A.elem)
+		case tupleType:
+			if len(A.elems) > 0 {
+				return c.refine(M.elem, c.joinElems(A))
+			}
+		}
+
+	case tupleType:
+		if A, ok := arg.(tupleType); ok && len(A.elems) != len(M.elems) {
+			return nil
+		}
+
+		for i, e := range M.elems {
+			var err error
+
+			switch A := arg.(type) {
+			case stringType:
+				err = c.refine(e, A)
+			case listType:
+				err = c.refine(e, A.elem)
+			case tupleType:
+				err = c.refine(e, A.elems[i])
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+	case patternType, *openPattern:
+		if isPattern(arg) {
+			c.tryUnify(match, arg)
+		}
+	}
+
+	return nil
+}
+
+func (c *checker) joinElems(t tupleType) Type {
+	var res Type = c.newVar()
+
+	for _, e := range t.elems {
+		res = c.join(res, e)
+	}
+
+	return res
+}
@@ -1,78 +1,462 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"./ast"
+	"./check"
+	"./slang"
 )
 
-func loadFile(path string) (*ast.SourceFile, error) {
-	// TODO: fix relative pathing
-	src, err := ioutil.ReadFile(path)
+const usage = `Usage: slang <command> [flags] [arguments]
+
+Commands:
+  run file.sl [--] [args]   run a program, its final value is the exit code
+  check file.sl...          type check files and their imports
+  fmt [-w] [-l] file.sl...  print files formatted, -w writes them back and
+                            -l lists the files that aren't formatted
+  test [path...]            run every *_test.sl file under the paths
+  repl                      start an interactive session
+  ast file.sl               print the parsed syntax tree of a file
+  cst file.sl               print the concrete syntax tree of a file, with
+                            every token and comment
+  tokens file.sl            print the tokens of a file
+
+A file given without a command is run.
+
+Flags:
+`
+
+// Flags shared by every command, they may come before or after it
+type options struct {
+	vm    bool
+	time  bool
+	quiet bool
+	trace bool
+
+	// Only taken by fmt
+	write bool
+	list  bool
+}
+
+func (o *options) flags(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.BoolVar(&o.vm, "vm", o.vm, "run on the bytecode vm instead of the tree walking evaluator")
+	flags.BoolVar(&o.time, "time", o.time, "print how long the command took")
+	flags.BoolVar(&o.quiet, "quiet", o.quiet, "only print errors, hiding what programs print and passing tests")
+	flags.BoolVar(&o.trace, "trace", o.trace, "print every application to stderr as it is evaluated")
+	flags.Usage = printUsage
+
+	if name == "fmt" {
+		flags.BoolVar(&o.write, "w", false, "write formatted files back instead of printing them")
+		flags.BoolVar(&o.list, "l", false, "list the files whose formatting differs instead of printing them")
+	}
+
+	return flags
+}
+
+func printUsage() {
+	fmt.Fprint(os.Stderr, usage)
+
+	flags := (&options{}).flags("slang")
+	flags.SetOutput(os.Stderr)
+	flags.PrintDefaults()
+}
+
+// Exit codes of the slang process
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+func (o *options) effects() *ast.Effects {
+	effects := ast.DefaultEffects()
+
+	if o.quiet {
+		effects.Stdout = ioutil.Discard
+	}
+
+	if o.trace {
+		effects.Trace = os.Stderr
+	}
+
+	return effects
+}
+
+func (o *options) interpreter(args []string) *slang.Interpreter {
+	interpreter := slang.New()
+	interpreter.UseVM = o.vm
+	interpreter.Effects = o.effects()
+	interpreter.Effects.Args = args
+
+	return interpreter
+}
+
+// Maps the final value of a program to the exit code of the process. Numbers
+// from 0 to 255 are the code, .fail, .false and [.fail, message] exit with 1
+// and the message, anything else succeeded.
+func exitCode(v ast.AST) (int, string) {
+	switch V := v.(type) {
+	case ast.Number:
+		if V.Value < 0 || V.Value > 255 {
+			return exitError, fmt.Sprintf("Exit code %d is outside of 0 to 255", V.Value)
+		}
+
+		return V.Value, ""
+
+	case ast.Decimal:
+		return exitError, fmt.Sprintf("Exit code %s is not a whole number", strings.Join(V.String(), ""))
+
+	case ast.Label:
+		if V.Value == "fail" {
+			return exitError, ""
+		}
+
+		if V.Value == "false" {
+			return exitError, "Evaluated to .false"
+		}
+
+	case ast.List:
+		if len(V.Values) == 2 && V.Values[0].Equals(ast.Label{Value: "fail"}) {
+			if msg, ok := V.Values[1].(ast.String); ok {
+				return exitError, msg.Value
+			}
+
+			return exitError, strings.Join(V.Values[1].String(), "\n")
+		}
+	}
+
+	return exitOK, ""
+}
+
+// Runs a file with the arguments following it, an optional -- separates them
+// from the file
+func runFile(opts *options, path string, args []string) int {
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+
+	srcFile, err := opts.interpreter(args).LoadFile(path)
 
 	if err != nil {
-		return nil, err
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
 	}
 
-	srcFile, err := ast.Parse(src)
+	code, msg := exitCode(srcFile.Definition)
+
+	if msg != "" {
+		fmt.Fprintln(os.Stderr, msg)
+	}
+
+	return code
+}
+
+// Type checks a file and its imports, returning whether it passed
+func checkFile(path string) bool {
+	srcFile, err := slang.ParseFile(path)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false
+	}
+
+	_, errs := check.Check(srcFile.Definition)
+
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+
+	return len(errs) == 0
+}
+
+// Parses a file on its own, without loading its imports
+func parseFile(path string) (*ast.SourceFile, error) {
+	src, err := ioutil.ReadFile(path)
 
 	if err != nil {
 		return nil, err
 	}
 
-	let, _ := ast.NewLet([]ast.Identifier{}, []ast.AST{}, nil)
+	return ast.Parse(path, src)
+}
 
-	for _, imp := range srcFile.Imports {
-		impSrcFile, err := loadFile(imp.Path)
+func formatFile(opts *options) func(string) bool {
+	return func(path string) bool {
+		src, err := ioutil.ReadFile(path)
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return false
+		}
+
+		formatted, err := ast.Format(path, src)
 
-		// ERRORING HERE???????????
 		if err != nil {
-			return nil, err
+			fmt.Fprintln(os.Stderr, err)
+			return false
 		}
 
-		name := imp.Name
+		changed := string(formatted) != string(src)
 
-		if name == "" {
-			name = impSrcFile.PackageName
+		if opts.list && changed {
+			fmt.Println(path)
 		}
 
-		let.Bind(ast.Identifier{name}, impSrcFile.Definition)
+		if opts.write && changed {
+			if err := ioutil.WriteFile(path, formatted, 0644); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return false
+			}
+		}
+
+		if !opts.list && !opts.write {
+			fmt.Print(string(formatted))
+		}
+
+		return true
 	}
+}
 
-	let.Body = srcFile.Definition
-	lib := ast.StdLib.Copy().(ast.Let)
-	lib.Body = let
-	srcFile.Definition = lib
-	srcFile.Definition, err = srcFile.Eval()
+func dumpFile(path string) bool {
+	srcFile, err := parseFile(path)
 
 	if err != nil {
-		return nil, err
+		fmt.Fprintln(os.Stderr, err)
+		return false
 	}
 
-	return srcFile, nil
+	fmt.Printf("Package %s\n", srcFile.PackageName)
+
+	for _, imp := range srcFile.Imports {
+		fmt.Printf("Import %q %s\n", imp.Path, imp.Pos)
+	}
+
+	fmt.Println(strings.Join(ast.Dump(srcFile.Definition), "\n"))
+
+	return true
 }
 
-func main() {
-	// Timer
-	startTime := time.Now()
-	defer func() {
-		fmt.Println(" ---\n Execution time:", time.Now().Sub(startTime))
-	}()
+func dumpCST(path string) bool {
+	src, err := ioutil.ReadFile(path)
 
-	switch len(os.Args) {
-	case 2:
-		// Read queue, loaded with first file
-		_, err := loadFile(os.Args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false
+	}
+
+	file, err := ast.ParseCST(path, src)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false
+	}
+
+	fmt.Println(strings.Join(file.Dump(), "\n"))
+
+	return true
+}
+
+func printTokens(path string) bool {
+	src, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false
+	}
+
+	tokens, err := ast.Tokenize(path, src)
+
+	for _, t := range tokens {
+		value := t.Value
+
+		// Strings may hold line breaks once their escapes are decoded
+		if t.Kind == "string" {
+			value = strconv.Quote(value)
+		}
+
+		fmt.Printf("%-12s %-12s %s\n", t.Pos, t.Kind, value)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false
+	}
+
+	return true
+}
+
+// Files ending in _test.sl under the paths, a test passes when evaluating it
+// succeeds with a value exiting 0
+func runTests(opts *options, paths []string) int {
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	files := []string{}
+
+	for _, path := range paths {
+		err := filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if !info.IsDir() && strings.HasSuffix(file, "_test.sl") {
+				files = append(files, file)
+			}
+
+			return nil
+		})
 
 		if err != nil {
-			fmt.Println(err)
-			return
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
 		}
+	}
+
+	failed := 0
+
+	for _, file := range files {
+		srcFile, err := opts.interpreter([]string{}).LoadFile(file)
+		msg := ""
+
+		if err != nil {
+			msg = err.Error()
+		} else if code, failure := exitCode(srcFile.Definition); code != exitOK {
+			msg = fmt.Sprintf("exited with %d", code)
+
+			if failure != "" {
+				msg += ": " + failure
+			}
+		}
+
+		if msg != "" {
+			failed++
+			fmt.Fprintf(os.Stderr, "FAIL %s\n%s\n", file, msg)
+		} else if !opts.quiet {
+			fmt.Printf("ok   %s\n", file)
+		}
+	}
+
+	if !opts.quiet {
+		fmt.Printf("%d passed, %d failed\n", len(files)-failed, failed)
+	}
+
+	if failed > 0 {
+		return exitError
+	}
+
+	return exitOK
+}
+
+// Runs a file command on each of its files, failing if any of them did
+func eachFile(files []string, fn func(string) bool) int {
+	if len(files) == 0 {
+		printUsage()
+		return exitUsage
+	}
+
+	code := exitOK
+
+	for _, file := range files {
+		if !fn(file) {
+			code = exitError
+		}
+	}
+
+	return code
+}
+
+func run(opts *options, args []string) int {
+	if len(args) == 0 {
+		printUsage()
+		return exitUsage
+	}
+
+	command := args[0]
+
+	switch command {
+	case "run", "check", "fmt", "test", "repl", "ast", "cst", "tokens":
+		flags := opts.flags(command)
+
+		if err := flags.Parse(args[1:]); err == flag.ErrHelp {
+			return exitOK
+		} else if err != nil {
+			return exitUsage
+		}
+
+		args = flags.Args()
+
+	case "help":
+		printUsage()
+		return exitOK
 
 	default:
-		fmt.Println("Unexpected number of args")
+		// A file without a command is run
+		command = "run"
 	}
+
+	switch command {
+	case "run":
+		if len(args) == 0 {
+			printUsage()
+			return exitUsage
+		}
+
+		return runFile(opts, args[0], args[1:])
+
+	case "check":
+		return eachFile(args, checkFile)
+
+	case "fmt":
+		return eachFile(args, formatFile(opts))
+
+	case "test":
+		return runTests(opts, args)
+
+	case "repl":
+		repl(opts.effects())
+		return exitOK
+
+	case "ast":
+		return eachFile(args, dumpFile)
+
+	case "cst":
+		return eachFile(args, dumpCST)
+
+	case "tokens":
+		return eachFile(args, printTokens)
+	}
+
+	return exitOK
+}
+
+func main() {
+	opts := &options{}
+	flags := opts.flags("slang")
+
+	if err := flags.Parse(os.Args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(exitOK)
+		}
+
+		os.Exit(exitUsage)
+	}
+
+	startTime := time.Now()
+	code := run(opts, flags.Args())
+
+	if opts.time {
+		fmt.Fprintln(os.Stderr, " ---\n Execution time:", time.Now().Sub(startTime))
+	}
+
+	os.Exit(code)
 }
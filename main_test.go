@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"./ast"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		value ast.AST
+		code  int
+		msg   string
+	}{
+		{ast.Number{Value: 0}, 0, ""},
+		{ast.Number{Value: 3}, 3, ""},
+		{ast.Number{Value: 255}, 255, ""},
+		{ast.Number{Value: 256}, exitError, "Exit code 256 is outside of 0 to 255"},
+		{ast.Number{Value: -1}, exitError, "Exit code -1 is outside of 0 to 255"},
+		{ast.Decimal{Value: 1.5}, exitError, "Exit code 1.5 is not a whole number"},
+		{ast.Label{Value: "ok"}, exitOK, ""},
+		{ast.Label{Value: "fail"}, exitError, ""},
+		{ast.Label{Value: "true"}, exitOK, ""},
+		{ast.Label{Value: "false"}, exitError, "Evaluated to .false"},
+		{ast.List{Values: []ast.AST{ast.Label{Value: "fail"}, ast.String{Value: "broken"}}}, exitError, "broken"},
+	}
+
+	for _, test := range tests {
+		if code, msg := exitCode(test.value); code != test.code || msg != test.msg {
+			t.Errorf("%v: expected %d %q, got %d %q", test.value.String(), test.code, test.msg, code, msg)
+		}
+	}
+}
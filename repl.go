@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+
+	"./ast"
+)
+
+// Counts unclosed braces, brackets and parenthesis so that multi line
+// patterns can be entered before parsing, a triple quoted string left open
+// counts as one too
+func openDelimiters(src []byte) int {
+	depth := 0
+
+	for i := 0; i < len(src); i++ {
+		switch c := src[i]; {
+		case c == '#':
+			for ; i < len(src) && src[i] != '\n'; i++ {
+			}
+
+		case bytes.HasPrefix(src[i:], []byte(`"""`)):
+			end := bytes.Index(src[i+3:], []byte(`"""`))
+
+			if end < 0 {
+				return depth + 1
+			}
+
+			i += end + 5
+
+		case c == '"':
+			for i++; i < len(src) && src[i] != '"' && src[i] != '\n'; i++ {
+				if src[i] == '\\' {
+					i++
+				}
+			}
+
+		case c == '{' || c == '[' || c == '(':
+			depth++
+		case c == '}' || c == ']' || c == ')':
+			depth--
+		}
+	}
+
+	return depth
+}
+
+func repl(effects *ast.Effects) {
+	// Every session starts with the standard library bound
+	env := ast.NewEnv(nil)
+	env.SetEffects(effects)
+
+	for i, id := range ast.StdLib.BoundIds {
+		val, err := ast.StdLib.BoundValues[i].Eval(env)
+
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		env.Set(id.Value, val)
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	src := []byte{}
+
+	fmt.Print("> ")
+
+	for scanner.Scan() {
+		src = append(src, scanner.Bytes()...)
+		src = append(src, '\n')
+
+		if openDelimiters(src) > 0 {
+			fmt.Print("... ")
+			continue
+		}
+
+		id, expr, err := ast.ParseStatement("repl", src)
+		src = []byte{}
+
+		switch {
+		case err != nil:
+			fmt.Println(err)
+
+		case expr == nil:
+			// Empty line
+
+		case id != nil:
+			// Bindings shadow previous ones, like a let
+			env = ast.NewEnv(env)
+			val, err := expr.Eval(env)
+
+			if err != nil {
+				fmt.Println(err)
+				break
+			}
+
+			env.Set(id.Value, val)
+
+		default:
+			val, err := expr.Eval(env)
+
+			if err != nil {
+				fmt.Println(err)
+				break
+			}
+
+			ast.Print(val)
+		}
+
+		fmt.Print("> ")
+	}
+
+	fmt.Println()
+}
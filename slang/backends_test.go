@@ -0,0 +1,164 @@
+package slang
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"../ast"
+)
+
+// Unique ids are numbered across the process, so each run names them apart
+var uniqueIds = regexp.MustCompile(`unique_[0-9]+`)
+
+// Runs a file on one backend, returning what it printed and its value
+func runBackend(path string, useVM bool) (string, string) {
+	out := &bytes.Buffer{}
+
+	in := New()
+	in.UseVM = useVM
+	in.Effects = ast.DefaultEffects()
+	in.Effects.Stdin = strings.NewReader("")
+	in.Effects.Stdout = out
+
+	file, err := in.LoadFile(path)
+	value := ""
+
+	if err != nil {
+		value = err.Error()
+	} else {
+		value = strings.Join(file.Definition.String(), "\n")
+	}
+
+	return uniqueIds.ReplaceAllString(out.String(), "unique"), uniqueIds.ReplaceAllString(value, "unique")
+}
+
+func TestBackendsAgreeOnBootstrap(t *testing.T) {
+	files, err := filepath.Glob("../bootstrap/*.sl")
+
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no bootstrap files: %v", err)
+	}
+
+	for _, file := range files {
+		file := file
+
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			t.Parallel()
+
+			treeOut, treeValue := runBackend(file, false)
+			vmOut, vmValue := runBackend(file, true)
+
+			if treeOut != vmOut {
+				t.Errorf("printed\n%s\non the tree walker and\n%s\non the vm", treeOut, vmOut)
+			}
+
+			if treeValue != vmValue {
+				t.Errorf("evaluated to\n%s\non the tree walker and\n%s\non the vm", treeValue, vmValue)
+			}
+		})
+	}
+}
+
+func TestBackendsAgreeOnStrings(t *testing.T) {
+	statements := []string{
+		`{ [c:cs] -> [c, cs] } "été"`,
+		`["é":"t"]`,
+		`{ [a:rest] -> [a, rest] } "日本語"`,
+	}
+
+	for _, useVM := range []bool{false, true} {
+		in := New()
+		in.UseVM = useVM
+
+		for _, statement := range statements {
+			res, err := in.EvalString(statement)
+
+			if err != nil {
+				t.Fatalf("%s: %v", statement, err)
+			}
+
+			expected := map[string]string{
+				statements[0]: `[ "é", "té" ]`,
+				statements[1]: `"ét"`,
+				statements[2]: `[ "日", "本語" ]`,
+			}[statement]
+
+			if got := strings.Join(res.String(), "\n"); got != expected {
+				t.Errorf("%s with vm %v: expected %s, got %s", statement, useVM, expected, got)
+			}
+		}
+	}
+}
+
+// Applications replaced by tail calls still show in traces
+func TestBackendsTraceTailCalls(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+
+		// Lines of the trace, in order
+		frames []string
+	}{
+		{
+			name: "tail call",
+			src:  "package t\n\nf = { x -> 1 / x }\n\nf 0\n",
+			frames: []string{
+				" > Division by zero",
+				" > t.sl:3:14 in application of /",
+				" > t.sl:5:1 in application of f",
+			},
+		},
+		{
+			name: "tail recursion",
+			src:  "package t\n\nboom = {\n  0 -> 1 / 0\n  n -> boom (n - 1)\n}\n\nboom 5\n",
+			frames: []string{
+				" > t.sl:4:10 in application of /",
+				" > t.sl:5:8 in application of boom",
+				"   ... repeated 4 more times",
+				" > t.sl:8:1 in application of boom",
+			},
+		},
+		{
+			name: "long tail recursion",
+			src:  "package t\n\nboom = {\n  0 -> 1 / 0\n  n -> boom (n - 1)\n}\n\nboom 100000\n",
+			frames: []string{
+				" > t.sl:5:8 in application of boom",
+				"   ... repeated 63 more times",
+				" > ... 99873 more tail calls",
+				" > t.sl:5:8 in application of boom",
+				"   ... repeated 62 more times",
+				" > t.sl:8:1 in application of boom",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "t.sl")
+
+		if err := ioutil.WriteFile(path, []byte(test.src), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, useVM := range []bool{false, true} {
+			in := New()
+			in.UseVM = useVM
+
+			_, err := in.LoadFile(path)
+
+			if err == nil {
+				t.Fatalf("%s with vm %v: expected dividing by zero to fail", test.name, useVM)
+			}
+
+			msg := strings.Replace(err.Error(), dir+string(filepath.Separator), "", -1)
+
+			if !strings.Contains(msg, strings.Join(test.frames, "\n")) {
+				t.Errorf("%s with vm %v: expected the trace to contain\n%s\ngot\n%s", test.name, useVM, strings.Join(test.frames, "\n"), msg)
+			}
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package slang
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"../ast"
+	"../vm"
+)
+
+// Labels other than .true and .false, which convert to bools
+type Label string
+
+// Converts a Go value to a slang value. Integers become numbers, floats
+// become decimals, bools the labels .true and .false, slices become lists and
+// maps from strings become records, patterns from labels to their values.
+// Slang values are returned as they are.
+func ToAST(v interface{}) (ast.AST, error) {
+	switch V := v.(type) {
+	case ast.AST:
+		return V, nil
+	case Label:
+		return ast.Label{Value: string(V)}, nil
+	case bool:
+		if V {
+			return ast.True, nil
+		}
+
+		return ast.False, nil
+	case string:
+		return ast.String{Value: V}, nil
+	}
+
+	val := reflect.ValueOf(v)
+
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return ast.Number{Value: int(val.Int())}, nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return ast.Number{Value: int(val.Uint())}, nil
+
+	case reflect.Float32, reflect.Float64:
+		return ast.Decimal{Value: val.Float()}, nil
+
+	case reflect.Slice, reflect.Array:
+		res := ast.List{Values: []ast.AST{}}
+
+		for i := 0; i < val.Len(); i++ {
+			elem, err := ToAST(val.Index(i).Interface())
+
+			if err != nil {
+				return nil, ast.NewRuntimeError(err, fmt.Sprintf("in element %d", i))
+			}
+
+			res.Values = append(res.Values, elem)
+		}
+
+		return res, nil
+
+	case reflect.Map:
+		if val.Type().Key().Kind() != reflect.String {
+			break
+		}
+
+		// Sorted so records print the same every time
+		keys := []string{}
+
+		for _, k := range val.MapKeys() {
+			keys = append(keys, k.String())
+		}
+
+		sort.Strings(keys)
+
+		matchGroups := [][]ast.AST{}
+		bodies := []ast.AST{}
+
+		for _, k := range keys {
+			body, err := ToAST(val.MapIndex(reflect.ValueOf(k).Convert(val.Type().Key())).Interface())
+
+			if err != nil {
+				return nil, ast.NewRuntimeError(err, fmt.Sprintf("in member %s", k))
+			}
+
+			matchGroups = append(matchGroups, []ast.AST{ast.Label{Value: k}})
+			bodies = append(bodies, body)
+		}
+
+		record, _ := ast.NewPattern(matchGroups, bodies)
+
+		return record.Eval(ast.NewEnv(nil))
+	}
+
+	return nil, ast.NewRuntimeError(nil, fmt.Sprintf("Cannot convert %T to a slang value", v))
+}
+
+// Converts a slang value to Go, the inverse of ToAST. Patterns that aren't
+// records are returned as they are so they can still be called.
+func FromAST(a ast.AST) (interface{}, error) {
+	switch A := a.(type) {
+	case ast.Number:
+		return A.Value, nil
+
+	case ast.Decimal:
+		return A.Value, nil
+
+	case ast.String:
+		return A.Value, nil
+
+	case ast.Label:
+		switch {
+		case A.Equals(ast.True):
+			return true, nil
+		case A.Equals(ast.False):
+			return false, nil
+		}
+
+		return Label(A.Value), nil
+
+	case ast.List:
+		res := []interface{}{}
+
+		for i, v := range A.Values {
+			elem, err := FromAST(v)
+
+			if err != nil {
+				return nil, ast.NewRuntimeError(err, fmt.Sprintf("in element %d", i))
+			}
+
+			res = append(res, elem)
+		}
+
+		return res, nil
+	}
+
+	if members, ok := recordMembers(a); ok {
+		res := map[string]interface{}{}
+
+		for _, member := range members {
+			v, err := a.Apply(member)
+
+			if err == nil {
+				res[member.Value], err = FromAST(v)
+			}
+
+			if err != nil {
+				return nil, ast.NewRuntimeError(err, fmt.Sprintf("in member %s", member.Value))
+			}
+		}
+
+		return res, nil
+	}
+
+	return a, nil
+}
+
+// Labels of a pattern whose every arm matches a single label
+func recordMembers(a ast.AST) ([]ast.Label, bool) {
+	var matchGroups [][]ast.AST
+
+	switch A := a.(type) {
+	case ast.Pattern:
+		matchGroups = A.Matches
+	case *vm.Closure:
+		matchGroups = A.Matches()
+	}
+
+	if len(matchGroups) == 0 {
+		return nil, false
+	}
+
+	res := []ast.Label{}
+
+	for _, matchGroup := range matchGroups {
+		if len(matchGroup) != 1 {
+			return nil, false
+		}
+
+		label, ok := matchGroup[0].(ast.Label)
+
+		if !ok {
+			return nil, false
+		}
+
+		res = append(res, label)
+	}
+
+	return res, true
+}
@@ -0,0 +1,58 @@
+package slang
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"../ast"
+)
+
+// Members of records are applied with the effects and limits of the
+// evaluation that created the record
+func TestFromASTAppliesMembersInTheirEvaluation(t *testing.T) {
+	for _, useVM := range []bool{false, true} {
+		out := &bytes.Buffer{}
+
+		in := New()
+		in.UseVM = useVM
+		in.Effects = ast.DefaultEffects()
+		in.Effects.Stdout = out
+
+		record, err := in.EvalString(`{ .a -> print "captured"; .b -> 2 }`)
+
+		if err != nil {
+			t.Fatalf("with vm %v: %v", useVM, err)
+		}
+
+		if _, err := FromAST(record); err != nil {
+			t.Fatalf("with vm %v: %v", useVM, err)
+		}
+
+		if out.String() != "\"captured\"\n" {
+			t.Errorf("with vm %v: expected the member to print to the effects, got %q", useVM, out.String())
+		}
+	}
+
+	for _, useVM := range []bool{false, true} {
+		in := New()
+		in.UseVM = useVM
+		in.Limits = ast.Limits{MaxSteps: 500}
+
+		if _, err := in.EvalString(forever); err != nil {
+			t.Fatalf("with vm %v: %v", useVM, err)
+		}
+
+		record, err := in.EvalString(`{ .a -> forever 0; .b -> 2 }`)
+
+		if err != nil {
+			t.Fatalf("with vm %v: %v", useVM, err)
+		}
+
+		var limit ast.LimitError
+
+		if _, err := FromAST(record); !errors.As(err, &limit) {
+			t.Errorf("with vm %v: expected a limit error, got %v", useVM, err)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package slang
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"../ast"
+)
+
+// Loads a source file on a backend, with an is_big builtin registered
+func loadGuards(t *testing.T, src string, useVM bool) (ast.AST, error) {
+	path := filepath.Join(t.TempDir(), "guards.sl")
+
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	in := New()
+	in.UseVM = useVM
+
+	in.Register("is_big", 1, func(args ast.Args) (ast.AST, error) {
+		n, err := args.Int(0)
+
+		return ast.Label{Value: map[bool]string{true: "true", false: "false"}[n > 100]}, err
+	})
+
+	file, err := in.LoadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return file.Definition, nil
+}
+
+// The examples of constant time guards in the README
+func TestConstantTimeGuards(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+
+		// The value evaluated to, or a part of the error for rejected guards
+		value string
+		err   string
+	}{
+		{
+			name: "builtins",
+			src: `
+sign = {
+  n :: (n > 0) -> .positive
+  n :: (n < 0) -> .negative
+  _            -> .zero
+}
+
+[sign 5, sign (0 - 2), sign 0]`,
+			value: "[ .positive, .negative, .zero ]",
+		},
+		{
+			name: "logical operators",
+			src: `
+short = {
+  s :: ((len s) <= 3 && s != "") -> .short
+  _                              -> .long
+}
+
+[short "ab", short "", short "abcd"]`,
+			value: "[ .short, .long, .long ]",
+		},
+		{
+			name: "registered builtin",
+			src: `
+size = {
+  n :: (is_big n) -> .big
+  _               -> .small
+}
+
+[size 1000, size 1]`,
+			value: "[ .big, .small ]",
+		},
+		{
+			name: "ordinary guard calling a pattern",
+			src: `
+is_even = { n -> (n % 2) == 0 }
+
+evens = {
+  []                   -> []
+  [n:ns] : (is_even n) -> [n] ++ evens ns
+  [n:ns]               -> evens ns
+}
+
+evens [1, 2, 3, 4]`,
+			value: "[ 2, 4 ]",
+		},
+		{
+			name: "pattern",
+			src: `
+is_even = { n -> (n % 2) == 0 }
+
+evens = {
+  []                    -> []
+  [n:ns] :: (is_even n) -> [n] ++ evens ns
+  [n:ns]                -> evens ns
+}
+
+evens [1, 2, 3, 4]`,
+			err: "Constant time guards may only apply builtins",
+		},
+		{
+			name: "applying a value",
+			src: `
+kind = {
+  token :: (token .type == .name) -> .name
+  _                               -> .other
+}
+
+kind { .type -> .name }`,
+			err: "Constant time guards may only apply builtins",
+		},
+		{
+			name: "shadowed builtin",
+			src: `
+len = { s -> 1 }
+
+short = {
+  s :: ((len s) <= 3) -> .short
+  _                   -> .long
+}
+
+short "abcd"`,
+			err: "Constant time guards may only apply builtins",
+		},
+		{
+			name: "effects",
+			src: `
+{
+  n :: (print n) -> n
+} 1`,
+			err: "Constant time guards may only apply builtins, 'print' is not one",
+		},
+		{
+			name: "pattern literal",
+			src: `
+{
+  n :: ({ x -> x } n) -> n
+} 1`,
+			err: "Constant time guards may only apply builtins",
+		},
+	}
+
+	for _, test := range tests {
+		for _, useVM := range []bool{false, true} {
+			res, err := loadGuards(t, "package guards\n"+test.src+"\n", useVM)
+
+			switch {
+			case test.err == "" && err != nil:
+				t.Errorf("%s with vm %v: %v", test.name, useVM, err)
+
+			case test.err == "" && strings.Join(res.String(), "\n") != test.value:
+				t.Errorf("%s with vm %v: expected %s, got %s", test.name, useVM, test.value, strings.Join(res.String(), "\n"))
+
+			case test.err != "" && err == nil:
+				t.Errorf("%s with vm %v: expected an error, got %s", test.name, useVM, strings.Join(res.String(), "\n"))
+
+			case test.err != "" && !strings.Contains(err.Error(), test.err):
+				t.Errorf("%s with vm %v: expected an error containing %q, got %v", test.name, useVM, test.err, err)
+			}
+		}
+	}
+}
+
+// Arms guarded alike share the result of the guard
+func TestConstantTimeGuardsFilterArms(t *testing.T) {
+	in := New()
+	tested := 0
+
+	in.Register("counted", 1, func(args ast.Args) (ast.AST, error) {
+		tested++
+
+		return ast.Label{Value: "true"}, nil
+	})
+
+	res, err := in.EvalString(`{ n :: (counted n) .a -> 1; n :: (counted n) .b -> 2; n :: (counted n) .c -> 3 } 5 .c`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !res.Equals(ast.Number{Value: 3}) || tested != 1 {
+		t.Errorf("expected 3 with the guard tested once, got %s tested %d times", strings.Join(res.String(), "\n"), tested)
+	}
+}
@@ -0,0 +1,268 @@
+package slang
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"../ast"
+)
+
+// Packages bundled with slang, importable by name from any file
+//
+//go:embed lib/*.sl
+var bundled embed.FS
+
+// Bundled packages are reported under this directory in errors
+const bundledDir = "<bundled>"
+
+// Packages written in Go, imported by name before any file is looked up
+var nativePackages = map[string]func() ast.AST{
+	"sys": ast.SysPackage,
+}
+
+// Finds the source of an import. Paths starting with ./ or ../ are only
+// relative to the importing file, other paths are also looked up in each
+// directory of SLANG_PATH and then the bundled packages. The extension may
+// be left off, so `import "std"` finds std.sl.
+func findImport(from string, imp ast.SourceFileImport) (string, []byte, error) {
+	path := imp.Path
+
+	if filepath.Ext(path) == "" {
+		path += ".sl"
+	}
+
+	if filepath.IsAbs(path) {
+		src, err := ioutil.ReadFile(path)
+
+		if err != nil {
+			return "", nil, ast.NewParseErrorAt(imp.Pos, err, fmt.Sprintf("Cannot read import \"%s\"", imp.Path))
+		}
+
+		return path, src, nil
+	}
+
+	dirs := []string{filepath.Dir(from)}
+	local := strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../")
+
+	if !local {
+		dirs = append(dirs, filepath.SplitList(os.Getenv("SLANG_PATH"))...)
+	}
+
+	for _, dir := range dirs {
+		if dir == "" || dir == bundledDir {
+			continue
+		}
+
+		candidate := filepath.Join(dir, path)
+		src, err := ioutil.ReadFile(candidate)
+
+		if err == nil {
+			return candidate, src, nil
+		}
+
+		if !os.IsNotExist(err) {
+			return "", nil, ast.NewParseErrorAt(imp.Pos, err, fmt.Sprintf("Cannot read import \"%s\"", imp.Path))
+		}
+	}
+
+	if !local {
+		if src, err := bundled.ReadFile("lib/" + filepath.ToSlash(path)); err == nil {
+			return filepath.Join(bundledDir, path), src, nil
+		}
+	}
+
+	return "", nil, ast.NewParseErrorAt(imp.Pos, nil, fmt.Sprintf("Cannot find import \"%s\"", imp.Path))
+}
+
+// Loads each package once, keyed by its canonical path, so files importing
+// the same package share its value
+type loader struct {
+	// Without an evaluator, files are only parsed and bound to their imports
+	eval func(context.Context, ast.AST) (ast.AST, error)
+
+	loaded map[string]*ast.SourceFile
+
+	// Files being loaded, from the first one to the innermost import
+	loading []loading
+}
+
+type loading struct {
+	key  string
+	path string
+}
+
+func newLoader(eval func(context.Context, ast.AST) (ast.AST, error)) *loader {
+	return &loader{eval, map[string]*ast.SourceFile{}, []loading{}}
+}
+
+// Bundled packages have no file to resolve
+func canonicalPath(path string) string {
+	if strings.HasPrefix(path, bundledDir) {
+		return path
+	}
+
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		path = real
+	}
+
+	return path
+}
+
+func (l *loader) loadFile(ctx context.Context, path string) (*ast.SourceFile, error) {
+	src, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return l.load(ctx, path, src)
+}
+
+func (l *loader) load(ctx context.Context, path string, src []byte) (*ast.SourceFile, error) {
+	key := canonicalPath(path)
+
+	if srcFile, ok := l.loaded[key]; ok {
+		return srcFile, nil
+	}
+
+	l.loading = append(l.loading, loading{key, path})
+	defer func() { l.loading = l.loading[:len(l.loading)-1] }()
+
+	srcFile, err := ast.Parse(path, src)
+
+	if err != nil {
+		return nil, err
+	}
+
+	let, _ := ast.NewLet([]ast.Identifier{}, []ast.AST{}, nil)
+
+	// Which import bound each name
+	boundBy := map[string]string{}
+
+	bind := func(name string, value ast.AST, imp ast.SourceFileImport) error {
+		if other, ok := boundBy[name]; ok {
+			return ast.NewParseErrorAt(imp.Pos, nil, fmt.Sprintf("Import of \"%s\" binds '%s', which the import of \"%s\" already binds", imp.Path, name, other))
+		}
+
+		boundBy[name] = imp.Path
+		let.Bind(ast.Identifier{Value: name, Pos: imp.Pos}, value)
+
+		return nil
+	}
+
+	for _, imp := range srcFile.Imports {
+		impSrcFile, err := l.loadImport(ctx, path, imp)
+
+		if err != nil {
+			return nil, err
+		}
+
+		name := imp.Name
+
+		if name == "" && len(imp.Names) == 0 {
+			name = impSrcFile.PackageName
+		}
+
+		if name != "" {
+			if err := bind(name, impSrcFile.Definition, imp); err != nil {
+				return nil, err
+			}
+		}
+
+		// Selected names are members of the package, applied in the
+		// program's environment so they run with its effects
+		for _, member := range imp.Names {
+			label := ast.Label{Value: member, Pos: imp.Pos}
+			value := ast.Application{Body: []ast.AST{impSrcFile.Definition, label}, Pos: imp.Pos}
+
+			if l.eval != nil && !hasMember(impSrcFile.Definition, label) {
+				return nil, ast.NewParseErrorAt(imp.Pos, nil, fmt.Sprintf("Package %s has no member '%s'", impSrcFile.PackageName, member))
+			}
+
+			if err := bind(member, value, imp); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	let.Body = srcFile.Definition
+	srcFile.Definition = let
+
+	if l.eval != nil {
+		if srcFile.Definition, err = l.eval(ctx, let); err != nil {
+			return nil, err
+		}
+	}
+
+	l.loaded[key] = srcFile
+
+	return srcFile, nil
+}
+
+// Packages that aren't records of labels may still have the member, it's
+// only known once they're applied
+func hasMember(pkg ast.AST, member ast.Label) bool {
+	members, ok := recordMembers(pkg)
+
+	if !ok {
+		return true
+	}
+
+	for _, m := range members {
+		if m.Equals(member) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (l *loader) loadImport(ctx context.Context, from string, imp ast.SourceFileImport) (*ast.SourceFile, error) {
+	if native, ok := nativePackages[imp.Path]; ok {
+		return &ast.SourceFile{PackageName: imp.Path, Imports: []ast.SourceFileImport{}, Definition: native()}, nil
+	}
+
+	impPath, impSrc, err := findImport(from, imp)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := l.checkCycle(impPath, imp); err != nil {
+		return nil, err
+	}
+
+	return l.load(ctx, impPath, impSrc)
+}
+
+// Errors with the chain of imports when a file imports one being loaded
+func (l *loader) checkCycle(path string, imp ast.SourceFileImport) error {
+	key := canonicalPath(path)
+
+	for i, curr := range l.loading {
+		if curr.key != key {
+			continue
+		}
+
+		chain := []string{}
+
+		for _, link := range l.loading[i:] {
+			chain = append(chain, link.path)
+		}
+
+		chain = append(chain, path)
+
+		return ast.NewParseErrorAt(imp.Pos, nil, "Import cycle: "+strings.Join(chain, " -> "))
+	}
+
+	return nil
+}
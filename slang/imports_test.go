@@ -0,0 +1,75 @@
+package slang
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"../ast"
+)
+
+// top imports left and right, which both import shared
+func TestDiamondImportsEvaluateSharedOnce(t *testing.T) {
+	for _, useVM := range []bool{false, true} {
+		out := &bytes.Buffer{}
+
+		in := New()
+		in.UseVM = useVM
+		in.Effects = ast.DefaultEffects()
+		in.Effects.Stdout = out
+
+		if _, err := in.LoadFile("testdata/diamond/top.sl"); err != nil {
+			t.Fatal(err)
+		}
+
+		if expected := "\"evaluating shared\"\n5\n"; out.String() != expected {
+			t.Errorf("with vm %v: expected %q, got %q", useVM, expected, out.String())
+		}
+	}
+}
+
+func TestImportCycleListsChain(t *testing.T) {
+	_, err := New().LoadFile("testdata/cycle/a.sl")
+
+	if err == nil {
+		t.Fatal("expected an import cycle error")
+	}
+
+	chain := "Import cycle: testdata/cycle/a.sl -> testdata/cycle/b.sl -> testdata/cycle/c.sl -> testdata/cycle/a.sl"
+
+	if !strings.Contains(err.Error(), chain) {
+		t.Errorf("expected an error containing %q, got %v", chain, err)
+	}
+}
+
+// Selected members run with the program's effects, like the package would
+func TestSelectiveImportsUseProgramEffects(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "args.sl")
+	src := "package args\n\nimport \"sys\" (args, env)\nimport \"sys\" as sys\n\n[args, sys.args, env \"HOME\"]\n"
+
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, useVM := range []bool{false, true} {
+		in := New()
+		in.UseVM = useVM
+		in.Effects = ast.DefaultEffects()
+		in.Effects.Args = []string{"a", "b"}
+		in.Effects.Env = func(name string) (string, bool) { return "/sandbox", true }
+
+		file, err := in.LoadFile(path)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := `[ [ "a", "b" ], [ "a", "b" ], [ .success, "/sandbox" ] ]`
+
+		if res := strings.Join(file.Definition.String(), "\n"); res != expected {
+			t.Errorf("with vm %v: expected %s, got %s", useVM, expected, res)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Package slang runs slang programs from Go. An Interpreter loads source
+// files and evaluates strings, and the values it produces can be applied
+// from Go or converted to Go values.
+package slang
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"../ast"
+	"../vm"
+)
+
+type Interpreter struct {
+	// Runs programs on the bytecode vm instead of walking the tree
+	UseVM bool
+
+	// Limits of each evaluation, a limit left at zero is off. Reaching one
+	// fails with an ast.LimitError, and a done context with its error.
+	Limits ast.Limits
+
+	// What builtins may do, nil for the effects of the host process
+	Effects *ast.Effects
+
+	machine  *vm.VM
+	loader   *loader
+	builtins *ast.Builtins
+
+	// Bindings made by EvalString
+	bindings ast.Let
+
+	// Source of the last statement evaluated that didn't bind anything,
+	// released once the next one is evaluated
+	statement string
+}
+
+// Strings evaluated by every interpreter, each is its own source in errors
+var evaluatedStrings int64
+
+func New() *Interpreter {
+	in := &Interpreter{machine: vm.New(), builtins: ast.DefaultBuiltins()}
+	in.loader = newLoader(in.eval)
+	in.bindings, _ = ast.NewLet([]ast.Identifier{}, []ast.AST{}, nil)
+
+	return in
+}
+
+// Registers a builtin for the files and strings evaluated afterwards. It's
+// curried, so it's applied to one argument at a time and fn is only called
+// once all arity arguments are applied. Registering a builtin of the same
+// name replaces it.
+func (in *Interpreter) Register(name string, arity int, fn func(ast.Args) (ast.AST, error)) error {
+	return in.builtins.Register(name, arity, fn)
+}
+
+// Evaluates with the builtins bound, within the limits
+func (in *Interpreter) eval(ctx context.Context, a ast.AST) (ast.AST, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	env := ast.NewLimitedEnv(ast.NewBudget(ctx, in.Limits))
+	env.SetEffects(in.Effects)
+
+	lib := in.builtins.Let()
+	lib.Body = a
+
+	if in.UseVM {
+		return in.machine.EvalIn(lib, env)
+	}
+
+	return lib.Eval(env)
+}
+
+func (in *Interpreter) LoadFile(path string) (*ast.SourceFile, error) {
+	return in.LoadFileContext(context.Background(), path)
+}
+
+// Loads and evaluates a file along with its imports. Each file is only
+// evaluated once, loading it again returns the same values.
+func (in *Interpreter) LoadFileContext(ctx context.Context, path string) (*ast.SourceFile, error) {
+	return in.loader.loadFile(ctx, path)
+}
+
+func (in *Interpreter) EvalString(src string) (ast.AST, error) {
+	return in.EvalStringContext(context.Background(), src)
+}
+
+// Evaluates a statement, without a package header, as the repl does. A
+// binding like 'x = 1' returns the bound value and keeps it bound for later
+// statements, the value may refer to itself to recurse. Empty statements
+// evaluate to nil. Errors display the source of the statement they came from
+// until the next statement is evaluated, or for as long as it's bound.
+func (in *Interpreter) EvalStringContext(ctx context.Context, src string) (ast.AST, error) {
+	if in.statement != "" {
+		ast.ReleaseSource(in.statement)
+	}
+
+	name := fmt.Sprintf("<string %d>", atomic.AddInt64(&evaluatedStrings, 1))
+	id, expr, err := ast.ParseStatement(name, []byte(src))
+	in.statement = name
+
+	if err != nil || expr == nil {
+		return nil, err
+	}
+
+	ids := append([]ast.Identifier{}, in.bindings.BoundIds...)
+	values := append([]ast.AST{}, in.bindings.BoundValues...)
+	let, _ := ast.NewLet(ids, values, expr)
+
+	// Bound in the same let as the earlier bindings, as a file binds them
+	if id != nil {
+		let.Bind(*id, expr)
+		let.Body = *id
+	}
+
+	val, err := in.eval(ctx, let)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if id != nil {
+		in.bindings.Bind(*id, val)
+		in.statement = ""
+	}
+
+	return val, nil
+}
+
+func (in *Interpreter) Call(fn ast.AST, args ...ast.AST) (ast.AST, error) {
+	return in.CallContext(context.Background(), fn, args...)
+}
+
+// Applies a pattern to each argument in turn
+func (in *Interpreter) CallContext(ctx context.Context, fn ast.AST, args ...ast.AST) (ast.AST, error) {
+	if len(args) == 0 {
+		return fn, nil
+	}
+
+	return in.eval(ctx, ast.Application{Body: append([]ast.AST{fn}, args...)})
+}
+
+// Parses a file and binds its imports without evaluating anything, as
+// needed to check it
+func ParseFile(path string) (*ast.SourceFile, error) {
+	return newLoader(nil).loadFile(context.Background(), path)
+}
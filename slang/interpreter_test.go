@@ -0,0 +1,118 @@
+package slang
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"../ast"
+)
+
+func TestEvalStringRecursiveBinding(t *testing.T) {
+	for _, useVM := range []bool{false, true} {
+		in := New()
+		in.UseVM = useVM
+
+		if _, err := in.EvalString("fact = { 0 -> 1; n -> n * (fact (n - 1)) }"); err != nil {
+			t.Fatalf("with vm %v: %v", useVM, err)
+		}
+
+		res, err := in.EvalString("fact 5")
+
+		if err != nil {
+			t.Fatalf("with vm %v: %v", useVM, err)
+		}
+
+		if !res.Equals(ast.Number{Value: 120}) {
+			t.Errorf("with vm %v: expected 120, got %s", useVM, strings.Join(res.String(), "\n"))
+		}
+	}
+}
+
+// Errors show the statement that failed, not the last one evaluated
+func TestEvalStringErrorsShowTheirSource(t *testing.T) {
+	in := New()
+
+	if _, err := in.EvalString("loop = { n -> n + .a }"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := in.EvalString("other = 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := in.EvalString("loop 0")
+
+	if err == nil {
+		t.Fatal("expected adding a label to fail")
+	}
+
+	if msg := err.Error(); !strings.Contains(msg, "1 | loop = { n -> n + .a }") || strings.Contains(msg, "1 | loop 0") {
+		t.Errorf("expected the error to show the statement binding loop, got %s", msg)
+	}
+}
+
+// Interpreters name their strings apart, so one doesn't display the other's
+func TestEvalStringSourcesAreNamedAcrossInterpreters(t *testing.T) {
+	a, b := New(), New()
+
+	if _, err := a.EvalString("fail = { n -> n / 0 }"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := a.EvalString("fail 1")
+
+	if err == nil {
+		t.Fatal("expected dividing by zero to fail")
+	}
+
+	if _, err := b.EvalString(`"completely different statement"`); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg := err.Error(); !strings.Contains(msg, "1 | fail = { n -> n / 0 }") || strings.Contains(msg, "completely different") {
+		t.Errorf("expected the error to show the statement binding fail, got %s", msg)
+	}
+}
+
+// Statements that don't bind anything are released by the next statement
+func TestEvalStringReleasesStatements(t *testing.T) {
+	in := New()
+	_, err := in.EvalString("1 / 0")
+
+	if err == nil {
+		t.Fatal("expected dividing by zero to fail")
+	}
+
+	if msg := err.Error(); !strings.Contains(msg, "1 | 1 / 0") {
+		t.Errorf("expected the error to show its statement, got %s", msg)
+	}
+
+	if _, err := in.EvalString("2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg := err.Error(); strings.Contains(msg, "1 | 1 / 0") {
+		t.Errorf("expected the statement to be released, got %s", msg)
+	}
+}
+
+// A failed match is only reported by its error
+func TestFailedMatchPrintsNothing(t *testing.T) {
+	for _, useVM := range []bool{false, true} {
+		out := &bytes.Buffer{}
+
+		in := New()
+		in.UseVM = useVM
+		in.Effects = ast.DefaultEffects()
+		in.Effects.Stdout = out
+
+		if _, err := in.EvalString("{ 1 -> 1 } 2"); err == nil {
+			t.Errorf("with vm %v: expected the match to fail", useVM)
+		}
+
+		if out.Len() > 0 {
+			t.Errorf("with vm %v: expected nothing printed, got %q", useVM, out.String())
+		}
+	}
+}
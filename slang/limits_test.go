@@ -0,0 +1,136 @@
+package slang
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"../ast"
+)
+
+// Loops forever, only ending on a limit or the context
+const forever = "forever = { n -> forever (n + 1) }"
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits ast.Limits
+		src    []string
+
+		// The limit reported
+		limit string
+	}{
+		{
+			name:   "steps",
+			limits: ast.Limits{MaxSteps: 500},
+			src:    []string{forever, "forever 0"},
+			limit:  "steps",
+		},
+		{
+			name:   "depth",
+			limits: ast.Limits{MaxDepth: 50},
+			src:    []string{"deep = { 0 -> 0; n -> 1 + (deep (n - 1)) }", "deep 1000"},
+			limit:  "nested applications",
+		},
+		{
+			name:   "allocation",
+			limits: ast.Limits{MaxAllocation: 100},
+			src:    []string{"grow = { 0 -> []; n -> [n : grow (n - 1)] }", "grow 1000"},
+			limit:  "allocated list elements and string bytes",
+		},
+		{
+			name:   "steps in a guard",
+			limits: ast.Limits{MaxSteps: 500},
+			src:    []string{forever, "{ n : (forever n) -> 1; n -> 2 } 100000"},
+			limit:  "steps",
+		},
+		{
+			name:   "depth in a guard",
+			limits: ast.Limits{MaxDepth: 50},
+			src:    []string{"deep = { 0 -> .true; n -> .true && (deep (n - 1)) }", "{ n : (deep n) -> 1; n -> 2 } 1000"},
+			limit:  "nested applications",
+		},
+	}
+
+	for _, test := range tests {
+		for _, useVM := range []bool{false, true} {
+			in := New()
+			in.UseVM = useVM
+			in.Limits = test.limits
+
+			var res ast.AST
+			var err error
+
+			for _, src := range test.src {
+				if res, err = in.EvalString(src); err != nil {
+					break
+				}
+			}
+
+			var limit ast.LimitError
+
+			if !errors.As(err, &limit) {
+				t.Errorf("%s with vm %v: expected a limit error, got %v and %v", test.name, useVM, res, err)
+			} else if limit.Limit != test.limit {
+				t.Errorf("%s with vm %v: expected the limit of %s, got %s", test.name, useVM, test.limit, limit.Limit)
+			}
+		}
+	}
+}
+
+// Limits are per evaluation, not per interpreter
+func TestLimitsResetEachEvaluation(t *testing.T) {
+	for _, useVM := range []bool{false, true} {
+		in := New()
+		in.UseVM = useVM
+		in.Limits = ast.Limits{MaxSteps: 500}
+
+		if _, err := in.EvalString("count = { 0 -> 0; n -> count (n - 1) }"); err != nil {
+			t.Fatalf("with vm %v: %v", useVM, err)
+		}
+
+		for i := 0; i < 5; i++ {
+			if _, err := in.EvalString("count 100"); err != nil {
+				t.Fatalf("with vm %v: evaluation %d: %v", useVM, i, err)
+			}
+		}
+	}
+}
+
+func TestCancellation(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{name: "loop", src: "forever 0"},
+		{name: "guard", src: "{ n : (forever n) -> 1; n -> 2 } 100000"},
+	}
+
+	for _, test := range tests {
+		for _, useVM := range []bool{false, true} {
+			in := New()
+			in.UseVM = useVM
+
+			if _, err := in.EvalString(forever); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			res, err := in.EvalStringContext(ctx, test.src)
+			cancel()
+
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("%s with vm %v: expected the deadline to be exceeded, got %v and %v", test.name, useVM, res, err)
+			}
+		}
+	}
+
+	in := New()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := in.EvalStringContext(ctx, "1"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a cancelled context to stop evaluating, got %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package vm
+
+import (
+	"../ast"
+)
+
+type armState struct {
+	arm   int
+	scope *scope
+}
+
+// Pattern values of the vm. A closure that has been applied to some arguments
+// only keeps the arms that matched them, each with its own scope of bindings.
+type Closure struct {
+	vm      *VM
+	proto   *proto
+	scope   *scope
+	arms    []armState
+	applied int
+
+	// Budget and effects of the evaluation that created it, used when it's
+	// applied from outside of a running evaluation
+	env *ast.Environment
+}
+
+// Prints as the source pattern, without the arms and arguments already applied
+func (c *Closure) String() []string {
+	if c.arms == nil {
+		return c.proto.source.String()
+	}
+
+	res, _ := ast.NewPattern([][]ast.AST{}, []ast.AST{})
+
+	for _, state := range c.arms {
+		res.Matches = append(res.Matches, c.proto.source.Matches[state.arm][c.applied:])
+		res.Bodies = append(res.Bodies, c.proto.source.Bodies[state.arm])
+	}
+
+	return res.String()
+}
+
+// Matches of the arms that remain to be applied
+func (c *Closure) Matches() [][]ast.AST {
+	if c.arms == nil {
+		return c.proto.source.Matches
+	}
+
+	res := [][]ast.AST{}
+
+	for _, state := range c.arms {
+		res = append(res, c.proto.source.Matches[state.arm][c.applied:])
+	}
+
+	return res
+}
+
+func (c *Closure) Equals(b interface{}) bool {
+	return false
+}
+
+func (c *Closure) Eval(*ast.Environment) (ast.AST, error) {
+	return c, nil
+}
+
+func (c *Closure) Apply(arg ast.AST) (ast.AST, error) {
+	prev := c.vm.env
+	c.vm.env = c.env
+	defer func() { c.vm.env = prev }()
+
+	return c.vm.call(c, []ast.AST{arg})
+}
+
+func (c *Closure) Copy() ast.AST {
+	return c
+}
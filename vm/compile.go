@@ -0,0 +1,518 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"../ast"
+)
+
+const (
+	_ = iota
+
+	OP_CONST      // const index
+	OP_LOAD       // load index
+	OP_LIST       // element count
+	OP_CONS       //
+	OP_CLOSURE    // proto index
+	OP_APPLY      // argument count, description index
+	OP_TAIL_APPLY // argument count, description index
+	OP_PUSH_SCOPE // slot count
+	OP_POP_SCOPE  //
+	OP_STORE      // slot
+	OP_POP        //
+	OP_LOGICAL    // short circuit value, jump target
+	OP_CHECK_BOOL // short circuit value
+	OP_ASSERT     //
+	OP_GUARD      // guard index
+	OP_RETURN     //
+)
+
+var opOperands = map[int]int{
+	OP_CONST:      1,
+	OP_LOAD:       1,
+	OP_LIST:       1,
+	OP_CONS:       0,
+	OP_CLOSURE:    1,
+	OP_APPLY:      2,
+	OP_TAIL_APPLY: 2,
+	OP_PUSH_SCOPE: 1,
+	OP_POP_SCOPE:  0,
+	OP_STORE:      1,
+	OP_POP:        0,
+	OP_LOGICAL:    2,
+	OP_CHECK_BOOL: 1,
+	OP_ASSERT:     0,
+	OP_GUARD:      1,
+	OP_RETURN:     0,
+}
+
+const (
+	_ = iota
+
+	MATCH_WILDCARD
+	MATCH_IDENTIFIER
+	MATCH_EQUALS
+	MATCH_LIST
+	MATCH_CONS
+	MATCH_WHERE
+)
+
+// Instructions are stored inline with their operands, positions are kept for
+// the opcode of each instruction. Operands index the program the chunk was
+// compiled in.
+type chunk struct {
+	prog      *program
+	code      []int
+	positions []ast.Position
+}
+
+func (c *chunk) emit(pos ast.Position, op int, operands ...int) int {
+	at := len(c.code)
+
+	c.code = append(c.code, op)
+	c.code = append(c.code, operands...)
+
+	for range append([]int{op}, operands...) {
+		c.positions = append(c.positions, pos)
+	}
+
+	return at
+}
+
+// Slot of an identifier relative to the scope it is resolved from
+type location struct {
+	depth int
+	slot  int
+}
+
+// An identifier may be declared in several enclosing scopes, the first bound
+// one at runtime is used. Lets bind in order, so a name can refer to the outer
+// binding until the inner one is set.
+type load struct {
+	name      string
+	locations []location
+}
+
+type matcher struct {
+	kind  int
+	value ast.AST
+	load  load
+	slot  int
+	elems []*matcher
+	guard *chunk
+
+	// Set for guards written with '::'
+	constantTime bool
+	pos          ast.Position
+}
+
+type arm struct {
+	slots   int
+	matches []*matcher
+	body    *chunk
+}
+
+type proto struct {
+	source ast.Pattern
+	arity  int
+	arms   []arm
+}
+
+type program struct {
+	consts       []ast.AST
+	loads        []load
+	protos       []*proto
+	descriptions []string
+
+	// Constant time guards outside of matches
+	guards []*chunk
+}
+
+type compileScope struct {
+	parent *compileScope
+	slots  map[string]int
+}
+
+func newCompileScope(parent *compileScope) *compileScope {
+	return &compileScope{parent, map[string]int{}}
+}
+
+func (s *compileScope) declare(name string) int {
+	if slot, ok := s.slots[name]; ok {
+		return slot
+	}
+
+	s.slots[name] = len(s.slots)
+
+	return s.slots[name]
+}
+
+type compiler struct {
+	prog  *program
+	scope *compileScope
+	chunk *chunk
+}
+
+func (c *compiler) constant(v ast.AST) int {
+	c.prog.consts = append(c.prog.consts, v)
+
+	return len(c.prog.consts) - 1
+}
+
+func (c *compiler) newChunk() *chunk {
+	return &chunk{prog: c.prog}
+}
+
+func (c *compiler) resolve(name string) int {
+	c.prog.loads = append(c.prog.loads, c.locate(name))
+
+	return len(c.prog.loads) - 1
+}
+
+func (c *compiler) locate(name string) load {
+	l := load{name, []location{}}
+	depth := 0
+
+	for s := c.scope; s != nil; s = s.parent {
+		if slot, ok := s.slots[name]; ok {
+			l.locations = append(l.locations, location{depth, slot})
+		}
+
+		depth++
+	}
+
+	return l
+}
+
+// Names the applied value for stack traces
+func describe(app ast.Application) string {
+	switch A := app.Body[0].(type) {
+	case ast.Identifier:
+		return fmt.Sprintf("in application of %s", A.Value)
+	case ast.Pattern:
+		return "in application of pattern"
+	}
+
+	return "in application"
+}
+
+// Logical operators short circuit to their value
+var logicalOps = map[string]bool{
+	"&&": false,
+	"||": true,
+}
+
+func boolOperand(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+func (c *compiler) Expression(a ast.AST, tail bool) error {
+	switch A := a.(type) {
+	case ast.Number, ast.Decimal, ast.String, ast.Label, ast.Builtin, *Closure:
+		c.chunk.emit(ast.Position{}, OP_CONST, c.constant(a))
+
+	case ast.Identifier:
+		c.chunk.emit(A.Pos, OP_LOAD, c.resolve(A.Value))
+
+	case ast.List:
+		for _, v := range A.Values {
+			if err := c.Expression(v, false); err != nil {
+				return err
+			}
+		}
+
+		c.chunk.emit(A.Pos, OP_LIST, len(A.Values))
+
+	case ast.ListConstructor:
+		if err := c.Expression(A.Head, false); err != nil {
+			return err
+		}
+
+		if err := c.Expression(A.Tail, false); err != nil {
+			return err
+		}
+
+		c.chunk.emit(A.Pos, OP_CONS)
+
+	case ast.Pattern:
+		// Patterns evaluated by the tree walker are values already
+		if len(A.Envs) > 0 {
+			c.chunk.emit(A.Pos, OP_CONST, c.constant(a))
+			break
+		}
+
+		index, err := c.Pattern(A)
+
+		if err != nil {
+			return err
+		}
+
+		c.chunk.emit(A.Pos, OP_CLOSURE, index)
+
+	case ast.Application:
+		return c.Application(A, tail)
+
+	case ast.Let:
+		return c.Let(A, tail)
+
+	case ast.Where:
+		if A.ConstantTime {
+			guard, err := c.Guard(A)
+
+			if err != nil {
+				return err
+			}
+
+			c.prog.guards = append(c.prog.guards, guard)
+			c.chunk.emit(A.Pos, OP_GUARD, len(c.prog.guards)-1)
+		} else if err := c.Expression(A.Condition, false); err != nil {
+			return err
+		}
+
+		c.chunk.emit(A.Pos, OP_ASSERT)
+
+		return c.Expression(A.Match, tail)
+
+	default:
+		return errors.New(fmt.Sprintf("Unhandled ast kind '%s' passed to compiler", reflect.TypeOf(a).Name()))
+	}
+
+	return nil
+}
+
+func (c *compiler) Application(app ast.Application, tail bool) error {
+	if op, ok := app.Body[0].(ast.Identifier); ok && len(app.Body) == 3 {
+		if shortCircuit, ok := logicalOps[op.Value]; ok {
+			if err := c.Expression(app.Body[1], false); err != nil {
+				return err
+			}
+
+			jump := c.chunk.emit(app.Pos, OP_LOGICAL, boolOperand(shortCircuit), 0)
+
+			if err := c.Expression(app.Body[2], false); err != nil {
+				return err
+			}
+
+			c.chunk.emit(app.Pos, OP_CHECK_BOOL, boolOperand(shortCircuit))
+			c.chunk.code[jump+2] = len(c.chunk.code)
+
+			return nil
+		}
+	}
+
+	for _, a := range app.Body {
+		if err := c.Expression(a, false); err != nil {
+			return err
+		}
+	}
+
+	c.prog.descriptions = append(c.prog.descriptions, describe(app))
+	op := OP_APPLY
+
+	if tail {
+		op = OP_TAIL_APPLY
+	}
+
+	c.chunk.emit(app.Pos, op, len(app.Body)-1, len(c.prog.descriptions)-1)
+
+	return nil
+}
+
+func (c *compiler) Let(let ast.Let, tail bool) error {
+	outer := c.scope
+	c.scope = newCompileScope(outer)
+
+	for _, id := range let.BoundIds {
+		if id.Value != "_" {
+			c.scope.declare(id.Value)
+		}
+	}
+
+	c.chunk.emit(let.Pos, OP_PUSH_SCOPE, len(c.scope.slots))
+
+	for i, id := range let.BoundIds {
+		if err := c.Expression(let.BoundValues[i], false); err != nil {
+			return err
+		}
+
+		if id.Value == "_" {
+			c.chunk.emit(id.Pos, OP_POP)
+		} else {
+			c.chunk.emit(id.Pos, OP_STORE, c.scope.slots[id.Value])
+		}
+	}
+
+	if err := c.Expression(let.Body, tail); err != nil {
+		return err
+	}
+
+	// Tail positions leave the frame, so the scope goes with it
+	if !tail {
+		c.chunk.emit(let.Pos, OP_POP_SCOPE)
+	}
+
+	c.scope = outer
+
+	return nil
+}
+
+func declareMatch(s *compileScope, m ast.AST) {
+	switch M := m.(type) {
+	case ast.Identifier:
+		if M.Value != "_" {
+			s.declare(M.Value)
+		}
+	case ast.List:
+		for _, v := range M.Values {
+			declareMatch(s, v)
+		}
+	case ast.ListConstructor:
+		declareMatch(s, M.Head)
+		declareMatch(s, M.Tail)
+	case ast.Where:
+		declareMatch(s, M.Match)
+	}
+}
+
+func (c *compiler) Match(m ast.AST) (*matcher, error) {
+	switch M := m.(type) {
+	case ast.Identifier:
+		if M.Value == "_" {
+			return &matcher{kind: MATCH_WILDCARD}, nil
+		}
+
+		return &matcher{kind: MATCH_IDENTIFIER, load: c.locate(M.Value), slot: c.scope.slots[M.Value]}, nil
+
+	case ast.List:
+		res := &matcher{kind: MATCH_LIST}
+
+		for _, v := range M.Values {
+			elem, err := c.Match(v)
+
+			if err != nil {
+				return nil, err
+			}
+
+			res.elems = append(res.elems, elem)
+		}
+
+		return res, nil
+
+	case ast.ListConstructor:
+		head, err := c.Match(M.Head)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tail, err := c.Match(M.Tail)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &matcher{kind: MATCH_CONS, elems: []*matcher{head, tail}}, nil
+
+	case ast.Where:
+		inner, err := c.Match(M.Match)
+
+		if err != nil {
+			return nil, err
+		}
+
+		guard, err := c.Guard(M)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &matcher{kind: MATCH_WHERE, elems: []*matcher{inner}, guard: guard, constantTime: M.ConstantTime, pos: M.Pos}, nil
+	}
+
+	// Anything else matches by equality, as in the tree walker
+	return &matcher{kind: MATCH_EQUALS, value: m}, nil
+}
+
+// Guards run in their own chunk, sharing the scope they are tested in
+func (c *compiler) Guard(where ast.Where) (*chunk, error) {
+	outer := c.chunk
+	c.chunk = c.newChunk()
+
+	if err := c.Expression(where.Condition, false); err != nil {
+		return nil, err
+	}
+
+	c.chunk.emit(where.Pos, OP_RETURN)
+	guard := c.chunk
+	c.chunk = outer
+
+	return guard, nil
+}
+
+func (c *compiler) Pattern(pattern ast.Pattern) (int, error) {
+	p := &proto{source: pattern}
+
+	if len(pattern.Matches) > 0 {
+		p.arity = len(pattern.Matches[0])
+	}
+
+	outerScope := c.scope
+	outerChunk := c.chunk
+
+	for i, matchGroup := range pattern.Matches {
+		c.scope = newCompileScope(outerScope)
+
+		// Declare every binding first so repeated identifiers test equality
+		for _, m := range matchGroup {
+			declareMatch(c.scope, m)
+		}
+
+		a := arm{}
+
+		for _, m := range matchGroup {
+			matcher, err := c.Match(m)
+
+			if err != nil {
+				return 0, err
+			}
+
+			a.matches = append(a.matches, matcher)
+		}
+
+		c.chunk = c.newChunk()
+
+		if err := c.Expression(pattern.Bodies[i], true); err != nil {
+			return 0, err
+		}
+
+		c.chunk.emit(pattern.Pos, OP_RETURN)
+
+		a.body = c.chunk
+		a.slots = len(c.scope.slots)
+		p.arms = append(p.arms, a)
+	}
+
+	c.scope = outerScope
+	c.chunk = outerChunk
+	c.prog.protos = append(c.prog.protos, p)
+
+	return len(c.prog.protos) - 1, nil
+}
+
+func compile(prog *program, a ast.AST) (*chunk, error) {
+	c := &compiler{prog, newCompileScope(nil), &chunk{prog: prog}}
+
+	if err := c.Expression(a, true); err != nil {
+		return nil, err
+	}
+
+	c.chunk.emit(ast.Position{}, OP_RETURN)
+
+	return c.chunk, nil
+}
@@ -0,0 +1,577 @@
+package vm
+
+import (
+	"../ast"
+)
+
+// Compiles and runs slang expressions as bytecode. Patterns become closures
+// over scopes of resolved slots, and pattern bodies run in explicit frames so
+// neither recursion nor tail calls grow the Go stack.
+type VM struct {
+	// Carries the budget and effects of the running evaluation, builtins
+	// are applied in it
+	env *ast.Environment
+}
+
+func New() *VM {
+	return &VM{ast.NewEnv(nil)}
+}
+
+// Evaluates an expression with the budget and effects of an environment
+func (vm *VM) EvalIn(a ast.AST, env *ast.Environment) (ast.AST, error) {
+	prev := vm.env
+	vm.env = env
+	defer func() { vm.env = prev }()
+
+	return vm.Eval(a)
+}
+
+// Compiles and evaluates an expression, usually the definition of a source
+// file. Each expression gets its own program, kept alive by the closures
+// created from it.
+func (vm *VM) Eval(a ast.AST) (ast.AST, error) {
+	chunk, err := compile(&program{}, a)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return vm.run(chunk, &scope{})
+}
+
+func Run(a ast.AST) (ast.AST, error) {
+	return New().Eval(a)
+}
+
+type scope struct {
+	parent *scope
+	slots  []ast.AST
+}
+
+func newScope(parent *scope, size int) *scope {
+	return &scope{parent, make([]ast.AST, size)}
+}
+
+func (s *scope) copy() *scope {
+	return &scope{s.parent, append([]ast.AST{}, s.slots...)}
+}
+
+// Unbound slots are nil
+func (s *scope) lookup(l load) (ast.AST, bool) {
+	depth := 0
+	curr := s
+
+	for _, loc := range l.locations {
+		for ; depth < loc.depth; depth++ {
+			curr = curr.parent
+		}
+
+		if v := curr.slots[loc.slot]; v != nil {
+			return v, true
+		}
+	}
+
+	return nil, false
+}
+
+type frame struct {
+	chunk *chunk
+	ip    int
+	last  int
+	scope *scope
+	stack []ast.AST
+
+	// Arguments left over once the body was reached, applied to its result
+	pending []ast.AST
+
+	// Counted against the depth of the budget
+	entered bool
+
+	// Frames this one replaced by tail calls, nil when it replaced none
+	tails *ast.TailFrames
+}
+
+func (f *frame) push(v ast.AST) {
+	f.stack = append(f.stack, v)
+}
+
+func (f *frame) pop() ast.AST {
+	v := f.stack[len(f.stack)-1]
+	f.stack = f.stack[:len(f.stack)-1]
+
+	return v
+}
+
+func (f *frame) operand() int {
+	v := f.chunk.code[f.ip]
+	f.ip++
+
+	return v
+}
+
+type exec struct {
+	vm     *VM
+	frames []*frame
+	result ast.AST
+	done   bool
+
+	// Constant time guards may only apply builtins
+	constantTime bool
+
+	// Frames entered in the budget, left again when they return
+	entered int
+}
+
+func (vm *VM) run(c *chunk, s *scope) (ast.AST, error) {
+	return vm.runGuard(c, s, false)
+}
+
+func (vm *VM) runGuard(c *chunk, s *scope, constantTime bool) (ast.AST, error) {
+	e := &exec{vm: vm, constantTime: constantTime}
+	e.frames = []*frame{{chunk: c, scope: s}}
+
+	for !e.done {
+		if err := e.step(); err != nil {
+			return nil, e.abort(err)
+		}
+	}
+
+	return e.result, nil
+}
+
+// Applies arguments to a value outside of a running frame
+func (vm *VM) call(fn ast.AST, args []ast.AST) (ast.AST, error) {
+	e := &exec{vm: vm}
+
+	if err := e.call(fn, args, false); err != nil {
+		return nil, err
+	}
+
+	for !e.done {
+		if err := e.step(); err != nil {
+			return nil, e.abort(err)
+		}
+	}
+
+	return e.result, nil
+}
+
+// Leaves the frames still running, wrapping the error with their applications
+func (e *exec) abort(err error) error {
+	for ; e.entered > 0; e.entered-- {
+		e.vm.env.Budget().Leave()
+	}
+
+	return e.trace(err)
+}
+
+func (e *exec) top() *frame {
+	return e.frames[len(e.frames)-1]
+}
+
+// Hands a value to the frame waiting on it
+func (e *exec) deliver(v ast.AST) {
+	if len(e.frames) == 0 {
+		e.result = v
+		e.done = true
+		return
+	}
+
+	e.top().push(v)
+}
+
+func (e *exec) ret(v ast.AST) error {
+	f := e.top()
+	e.frames = e.frames[:len(e.frames)-1]
+
+	if f.entered {
+		e.vm.env.Budget().Leave()
+		e.entered--
+	}
+
+	if len(f.pending) > 0 {
+		return e.call(v, f.pending, false)
+	}
+
+	e.deliver(v)
+
+	return nil
+}
+
+// Applies arguments one at a time. Reaching the body of a closure pushes a
+// frame for it, or replaces the current frame for calls in tail position.
+func (e *exec) call(fn ast.AST, args []ast.AST, tail bool) error {
+	for i, arg := range args {
+		if e.constantTime && !ast.IsConstantTime(fn) {
+			return ast.NewRuntimeError(nil, "Constant time guards may only apply builtins")
+		}
+
+		if err := e.vm.env.Budget().Step(); err != nil {
+			return err
+		}
+
+		if c, ok := fn.(*Closure); ok && c.vm == e.vm {
+			next, body, s, err := e.apply(c, arg)
+
+			if err != nil {
+				return err
+			}
+
+			if body != nil {
+				f := &frame{chunk: body, scope: s, stack: make([]ast.AST, 0, 8), pending: args[i+1:]}
+
+				// Tail calls replace the current frame, and its depth
+				if tail {
+					curr := e.top()
+					e.frames = e.frames[:len(e.frames)-1]
+					f.pending = append(append([]ast.AST{}, f.pending...), curr.pending...)
+					f.entered = curr.entered
+					f.tails = curr.tails
+
+					if f.tails == nil {
+						f.tails = &ast.TailFrames{}
+					}
+
+					f.tails.Push(curr.application())
+				} else {
+					if err := e.vm.env.Budget().Enter(); err != nil {
+						return err
+					}
+
+					f.entered = true
+					e.entered++
+				}
+
+				e.frames = append(e.frames, f)
+
+				return nil
+			}
+
+			fn = next
+			continue
+		}
+
+		var err error
+		fn, err = ast.ApplyIn(fn, arg, e.vm.env)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if tail {
+		return e.ret(fn)
+	}
+
+	e.deliver(fn)
+
+	return nil
+}
+
+// Matches the next argument against the remaining arms, returning either the
+// narrowed closure or the body of the first arm once all arguments are matched
+func (e *exec) apply(c *Closure, arg ast.AST) (*Closure, *chunk, *scope, error) {
+	p := c.proto
+	res := &Closure{vm: c.vm, env: e.vm.env, proto: p, scope: c.scope, applied: c.applied + 1}
+
+	states := c.arms
+
+	if states == nil {
+		states = make([]armState, len(p.arms))
+
+		for i := range p.arms {
+			states[i] = armState{i, nil}
+		}
+	}
+
+	res.arms = make([]armState, 0, len(states))
+
+	for _, state := range states {
+		a := p.arms[state.arm]
+		var s *scope
+
+		if state.scope == nil {
+			s = newScope(c.scope, a.slots)
+		} else {
+			s = state.scope.copy()
+		}
+
+		matched, err := e.match(a.matches[c.applied], arg, s)
+
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		if matched {
+			res.arms = append(res.arms, armState{state.arm, s})
+		}
+	}
+
+	if len(res.arms) == 0 {
+		return nil, nil, nil, ast.NewRuntimeErrorAt(p.source.Pos, nil, "Unable to match any values to pattern")
+	}
+
+	if res.applied == p.arity {
+		return nil, p.arms[res.arms[0].arm].body, res.arms[0].scope, nil
+	}
+
+	return res, nil, nil, nil
+}
+
+// Only constant time guards report errors, other guards that fail to
+// evaluate just don't match
+func (e *exec) match(m *matcher, val ast.AST, s *scope) (bool, error) {
+	switch m.kind {
+	case MATCH_WILDCARD:
+		return true, nil
+
+	case MATCH_IDENTIFIER:
+		if v, ok := s.lookup(m.load); ok {
+			return v.Equals(val), nil
+		}
+
+		s.slots[m.slot] = val
+
+		return true, nil
+
+	case MATCH_EQUALS:
+		return m.value.Equals(val), nil
+
+	case MATCH_LIST:
+		switch V := val.(type) {
+		case ast.List:
+			if len(m.elems) != len(V.Values) {
+				return false, nil
+			}
+
+			for i := range V.Values {
+				if matched, err := e.match(m.elems[i], V.Values[i], s); !matched || err != nil {
+					return false, err
+				}
+			}
+
+			return true, nil
+
+		case ast.String:
+			return len(m.elems) == 0 && len(V.Value) == 0, nil
+		}
+
+	case MATCH_CONS:
+		switch V := val.(type) {
+		case ast.List:
+			if len(V.Values) > 0 {
+				return e.matchBoth(m, V.Values[0], ast.List{Values: V.Values[1:]}, s)
+			}
+
+		case ast.String:
+			if len(V.Value) > 0 {
+				head, tail := ast.SplitString(V)
+
+				return e.matchBoth(m, head, tail, s)
+			}
+		}
+
+	case MATCH_WHERE:
+		if matched, err := e.match(m.elems[0], val, s); !matched || err != nil {
+			return false, err
+		}
+
+		res, err := e.vm.runGuard(m.guard, s, m.constantTime)
+
+		if m.constantTime {
+			if err != nil {
+				return false, ast.NewRuntimeErrorAt(m.pos, err, "in constant time guard")
+			}
+
+			return res.Equals(ast.True), nil
+		}
+
+		if ast.IsLimitError(err) {
+			return false, err
+		}
+
+		return err == nil && res.Equals(ast.True), nil
+	}
+
+	return false, nil
+}
+
+func (e *exec) matchBoth(m *matcher, head ast.AST, tail ast.AST, s *scope) (bool, error) {
+	if matched, err := e.match(m.elems[0], head, s); !matched || err != nil {
+		return false, err
+	}
+
+	return e.match(m.elems[1], tail, s)
+}
+
+func isBool(v ast.AST) bool {
+	return v.Equals(ast.True) || v.Equals(ast.False)
+}
+
+func boolLabel(b bool) ast.Label {
+	if b {
+		return ast.True
+	}
+
+	return ast.False
+}
+
+func logicalOp(shortCircuit int) string {
+	if shortCircuit == 1 {
+		return "||"
+	}
+
+	return "&&"
+}
+
+func cons(pos ast.Position, head ast.AST, tail ast.AST) (ast.AST, error) {
+	switch T := tail.(type) {
+	case ast.List:
+		return ast.List{Values: append([]ast.AST{head}, T.Values...)}, nil
+
+	case ast.String:
+		if H, ok := head.(ast.String); ok && ast.IsCharacter(H) {
+			return ast.String{Value: H.Value + T.Value}, nil
+		}
+
+		return nil, ast.NewRuntimeErrorAt(pos, nil, "List constructor with a string tail must have a single character string head")
+	}
+
+	return nil, ast.NewRuntimeErrorAt(pos, nil, "List constructor tail must be a list or string")
+}
+
+func (e *exec) step() error {
+	f := e.top()
+	f.last = f.ip
+	op := f.operand()
+	pos := f.chunk.positions[f.last]
+
+	switch op {
+	case OP_CONST:
+		f.push(f.chunk.prog.consts[f.operand()])
+
+	case OP_LOAD:
+		l := f.chunk.prog.loads[f.operand()]
+		v, ok := f.scope.lookup(l)
+
+		if !ok {
+			return ast.NewRuntimeErrorAt(pos, nil, "Cannot get value for identifier '"+l.name+"'")
+		}
+
+		f.push(v)
+
+	case OP_LIST:
+		n := f.operand()
+
+		if err := e.vm.env.Budget().Allocate(n); err != nil {
+			return ast.NewRuntimeErrorAt(pos, err, "in list")
+		}
+		values := append([]ast.AST{}, f.stack[len(f.stack)-n:]...)
+		f.stack = f.stack[:len(f.stack)-n]
+		f.push(ast.List{Values: values})
+
+	case OP_CONS:
+		tail := f.pop()
+		head := f.pop()
+
+		if err := e.vm.env.Budget().Allocate(ast.Allocation(tail) + 1); err != nil {
+			return ast.NewRuntimeErrorAt(pos, err, "in list constructor")
+		}
+
+		v, err := cons(pos, head, tail)
+
+		if err != nil {
+			return err
+		}
+
+		f.push(v)
+
+	case OP_CLOSURE:
+		f.push(&Closure{vm: e.vm, env: e.vm.env, proto: f.chunk.prog.protos[f.operand()], scope: f.scope})
+
+	case OP_APPLY, OP_TAIL_APPLY:
+		n := f.operand()
+		ast.TraceApplication(e.vm.env, pos, f.chunk.prog.descriptions[f.operand()])
+
+		args := append([]ast.AST{}, f.stack[len(f.stack)-n:]...)
+		f.stack = f.stack[:len(f.stack)-n]
+		fn := f.pop()
+
+		return e.call(fn, args, op == OP_TAIL_APPLY)
+
+	case OP_PUSH_SCOPE:
+		f.scope = newScope(f.scope, f.operand())
+
+	case OP_POP_SCOPE:
+		f.scope = f.scope.parent
+
+	case OP_STORE:
+		f.scope.slots[f.operand()] = f.pop()
+
+	case OP_POP:
+		f.pop()
+
+	case OP_LOGICAL:
+		shortCircuit := f.operand()
+		target := f.operand()
+		v := f.pop()
+
+		if !isBool(v) {
+			return ast.NewRuntimeErrorAt(pos, nil, "Can't apply '"+logicalOp(shortCircuit)+"' on non-boolean type")
+		}
+
+		if v.Equals(boolLabel(shortCircuit == 1)) {
+			f.push(v)
+			f.ip = target
+		}
+
+	case OP_CHECK_BOOL:
+		shortCircuit := f.operand()
+
+		if !isBool(f.stack[len(f.stack)-1]) {
+			return ast.NewRuntimeErrorAt(pos, nil, "Can't apply '"+logicalOp(shortCircuit)+"' on non-boolean type")
+		}
+
+	case OP_GUARD:
+		v, err := e.vm.runGuard(f.chunk.prog.guards[f.operand()], f.scope, true)
+
+		if err != nil {
+			return ast.NewRuntimeErrorAt(pos, err, "in constant time guard")
+		}
+
+		f.push(v)
+
+	case OP_ASSERT:
+		if !f.pop().Equals(ast.True) {
+			return ast.NewRuntimeErrorAt(pos, nil, "Where condition does not hold")
+		}
+
+	case OP_RETURN:
+		return e.ret(f.pop())
+	}
+
+	return nil
+}
+
+// Wraps an error with the applications of every frame still running, and
+// of the frames they replaced by tail calls
+func (e *exec) trace(err error) error {
+	for i := len(e.frames) - 1; i >= 0; i-- {
+		f := e.frames[i]
+		op := f.chunk.code[f.last]
+
+		if op == OP_APPLY || op == OP_TAIL_APPLY {
+			pos, description := f.application()
+			err = ast.NewRuntimeErrorAt(pos, err, description)
+		}
+
+		err = f.tails.Wrap(err)
+	}
+
+	return err
+}
+
+// Position and description of the application the frame last ran
+func (f *frame) application() (ast.Position, string) {
+	return f.chunk.positions[f.last], f.chunk.prog.descriptions[f.chunk.code[f.last+2]]
+}